@@ -0,0 +1,31 @@
+package watchman
+
+// API is the set of watchman commands implemented by Client and Pool,
+// so code using them can be tested against a fake such as the one in
+// package watchmantest. It only covers the commands Client has methods
+// for; others, such as query, trigger and state-enter, can be sent with
+// Send or Go until they are implemented.
+type API interface {
+	Send(dest interface{}, args ...interface{}) error
+	Go(dest interface{}, args ...interface{}) *Call
+	NewBatch() *Batch
+	Receive(ch chan<- interface{}) (func(), error)
+	Close() error
+
+	Clock(path string) (*Clock, error)
+	Find(path string, patterns ...string) (*Find, error)
+	LogLevel(level string) (*LogLevel, error)
+	Subscribe(path, name string, expr map[string]interface{}, ch chan<- *SubscribeEvent) (*Subscribe, func(), error)
+	Unsubscribe(path, name string) (*Unsubscribe, error)
+	Version() (*Version, error)
+	Watch(path string) (*Watch, error)
+	WatchDel(path string) (*WatchDel, error)
+	WatchDelAll(path string) (*WatchDelAll, error)
+	WatchList() (*WatchList, error)
+	WatchProject(path string) (*WatchProject, error)
+}
+
+var (
+	_ API = (*Client)(nil)
+	_ API = (*Pool)(nil)
+)
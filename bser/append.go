@@ -0,0 +1,102 @@
+package bser
+
+import "math"
+
+// The Append functions append the BSER encoding of a single value to buf
+// and return the extended buffer. They produce the same bytes as the
+// reflection based encoder for the same value.
+
+// AppendNull appends a null value
+func AppendNull(buf []byte) []byte {
+	return append(buf, byte(TypeNull))
+}
+
+// AppendMissing appends the marker for a value missing from a row of
+// a templated array
+func AppendMissing(buf []byte) []byte {
+	return append(buf, byte(TypeMissing))
+}
+
+// AppendBool appends a boolean value
+func AppendBool(buf []byte, v bool) []byte {
+	if v {
+		return append(buf, byte(TypeTrue))
+	}
+	return append(buf, byte(TypeFalse))
+}
+
+// AppendString appends a string value
+func AppendString(buf []byte, v string) []byte {
+	return appendString(buf, v)
+}
+
+// AppendBytes appends b as a string without assuming it is valid UTF-8.
+// A nil b is appended as null, as the reflection based encoder does.
+func AppendBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return AppendNull(buf)
+	}
+	return appendBytes(buf, b)
+}
+
+// AppendInt appends v using the smallest integer type that can hold it
+func AppendInt(buf []byte, v int) []byte {
+	return appendInt(buf, int64(v), fitInt(v))
+}
+
+// AppendInt8 appends an int8 value
+func AppendInt8(buf []byte, v int8) []byte {
+	return appendInt(buf, int64(v), byte(TypeInt8))
+}
+
+// AppendInt16 appends an int16 value
+func AppendInt16(buf []byte, v int16) []byte {
+	return appendInt(buf, int64(v), byte(TypeInt16))
+}
+
+// AppendInt32 appends an int32 value
+func AppendInt32(buf []byte, v int32) []byte {
+	return appendInt(buf, int64(v), byte(TypeInt32))
+}
+
+// AppendInt64 appends an int64 value
+func AppendInt64(buf []byte, v int64) []byte {
+	return appendInt(buf, v, byte(TypeInt64))
+}
+
+// AppendReal appends a real value
+func AppendReal(buf []byte, v float64) []byte {
+	buf = append(buf, byte(TypeReal))
+	return appendUint64(buf, math.Float64bits(v))
+}
+
+// AppendArrayHeader appends the header of an array of n values.
+// The n values must be appended next.
+func AppendArrayHeader(buf []byte, n int) []byte {
+	buf = append(buf, byte(TypeArray))
+	return AppendInt(buf, n)
+}
+
+// AppendObjectHeader appends the header of an object with n fields.
+// The n key/value pairs must be appended next, each key as a string.
+func AppendObjectHeader(buf []byte, n int) []byte {
+	buf = append(buf, byte(TypeObject))
+	return AppendInt(buf, n)
+}
+
+// AppendTemplateHeader appends the header of a templated array of n
+// objects sharing the field names. n*len(names) values must be appended
+// next, row by row in the order of names.
+func AppendTemplateHeader(buf []byte, names []string, n int) []byte {
+	buf = append(buf, byte(TypeTemplate))
+	buf = AppendArrayHeader(buf, len(names))
+	for _, name := range names {
+		buf = appendString(buf, name)
+	}
+	return AppendInt(buf, n)
+}
+
+// AppendValue appends the encoding of v using the reflection based encoder
+func AppendValue(buf []byte, v interface{}) ([]byte, error) {
+	return encode(buf, v, encodeOptions{})
+}
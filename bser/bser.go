@@ -1,41 +1,140 @@
 package bser
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	protocolPrefix  = []byte{0, 1}
-	order           = binary.LittleEndian
-	typString       = reflect.TypeOf("")
-	typInt          = reflect.TypeOf(int(0))
-	typInt8         = reflect.TypeOf(int8(0))
-	typInt16        = reflect.TypeOf(int16(0))
-	typInt32        = reflect.TypeOf(int32(0))
-	typInt64        = reflect.TypeOf(int64(0))
-	typFloat32      = reflect.TypeOf(float32(0))
-	typFloat64      = reflect.TypeOf(float64(0))
-	typGenericSlice = reflect.TypeOf([]interface{}{})
-	typGenericMap   = reflect.TypeOf(map[string]interface{}{})
-	typBool         = reflect.TypeOf(true)
-	typMarshaler    = reflect.TypeOf((*Marshaler)(nil)).Elem()
-	typUnmarshaler  = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	protocolPrefix     = []byte{0, 1}
+	order              = binary.LittleEndian
+	typString          = reflect.TypeOf("")
+	typInt             = reflect.TypeOf(int(0))
+	typInt8            = reflect.TypeOf(int8(0))
+	typInt16           = reflect.TypeOf(int16(0))
+	typInt32           = reflect.TypeOf(int32(0))
+	typInt64           = reflect.TypeOf(int64(0))
+	typFloat32         = reflect.TypeOf(float32(0))
+	typFloat64         = reflect.TypeOf(float64(0))
+	typGenericSlice    = reflect.TypeOf([]interface{}{})
+	typGenericMap      = reflect.TypeOf(map[string]interface{}{})
+	typBool            = reflect.TypeOf(true)
+	typMarshaler       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	typUnmarshaler     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	typRawMessagePtr   = reflect.TypeOf((*RawMessage)(nil))
+	typTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	typTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
 
-type structFields map[string]field
+// Type is a BSER type marker
+type Type byte
 
-func (s structFields) field(name string) (field, bool) {
-	if f, ok := s[name]; ok {
-		return f, true
+// the BSER type markers
+const (
+	TypeArray    Type = 0x00
+	TypeObject   Type = 0x01
+	TypeString   Type = 0x02
+	TypeInt8     Type = 0x03
+	TypeInt16    Type = 0x04
+	TypeInt32    Type = 0x05
+	TypeInt64    Type = 0x06
+	TypeReal     Type = 0x07
+	TypeTrue     Type = 0x08
+	TypeFalse    Type = 0x09
+	TypeNull     Type = 0x0a
+	TypeTemplate Type = 0x0b
+	TypeMissing  Type = 0x0c
+	// TypeUTF8String is a string known to be valid UTF-8, sent by
+	// version 2 peers in place of TypeString
+	TypeUTF8String Type = 0x0d
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeArray:
+		return "array"
+	case TypeObject:
+		return "object"
+	case TypeString:
+		return "string"
+	case TypeInt8:
+		return "int8"
+	case TypeInt16:
+		return "int16"
+	case TypeInt32:
+		return "int32"
+	case TypeInt64:
+		return "int64"
+	case TypeReal:
+		return "real"
+	case TypeTrue, TypeFalse:
+		return "bool"
+	case TypeNull:
+		return "null"
+	case TypeTemplate:
+		return "template"
+	case TypeMissing:
+		return "missing"
+	case TypeUTF8String:
+		return "utf8string"
+	default:
+		return fmt.Sprintf("unknown(%x)", byte(t))
 	}
+}
 
-	for k, f := range s {
-		if strings.EqualFold(k, name) {
-			return f, true
-		}
+// FieldSet maps the keys of an object to the positions of a list of
+// field names. A key matches the name it equals, or failing that a name
+// that equals it without regard to case, as the reflection based decoder
+// matches keys to struct fields.
+type FieldSet struct {
+	byName map[string]int
+	byFold map[string]int
+}
+
+// NewFieldSet returns a FieldSet for names
+func NewFieldSet(names ...string) *FieldSet {
+	s := &FieldSet{byName: map[string]int{}, byFold: map[string]int{}}
+	for i, name := range names {
+		s.byName[name] = i
+		s.byFold[strings.ToLower(name)] = i
+	}
+
+	return s
+}
+
+// Index returns the position of name, or -1 if it is not in the set
+func (s *FieldSet) Index(name []byte) int {
+	if i, ok := s.byName[string(name)]; ok {
+		return i
+	}
+
+	if i, ok := s.byFold[string(bytes.ToLower(name))]; ok {
+		return i
+	}
+
+	return -1
+}
+
+// structFields is the cached codec plan for a struct type
+type structFields struct {
+	// list holds the fields to encode, in declaration order
+	list []field
+	// all holds every decodable field, including embedded structs
+	all []field
+	// names indexes all
+	names *FieldSet
+}
+
+func (s *structFields) field(name []byte) (field, bool) {
+	if i := s.names.Index(name); i >= 0 {
+		return s.all[i], true
 	}
 
 	return field{}, false
@@ -44,16 +143,39 @@ func (s structFields) field(name string) (field, bool) {
 type field struct {
 	Name  string
 	Index []int
+	Type  reflect.Type
+	// Unit is the unit of a time.Time or time.Duration field set
+	// by its tag, or 0 for the default
+	Unit time.Duration
+	// OmitEmpty is set by the omitempty tag option. Empty values are left
+	// out of objects and encoded as missing in templated arrays.
+	OmitEmpty bool
 }
 
-func fields(t reflect.Type) structFields {
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// fields returns the cached codec plan for t
+func fields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+func typeFields(t reflect.Type) *structFields {
 	type ftyp struct {
 		typ   reflect.Type
 		index []int
 	}
 
-	fields := structFields{}
-	typs := []ftyp{{t, []int{}}}
+	var (
+		fields = &structFields{}
+		names  = []string{}
+		encode = map[string]int{}
+		typs   = []ftyp{{t, []int{}}}
+	)
 
 	for len(typs) > 0 {
 		typ := typs[0]
@@ -71,38 +193,84 @@ func fields(t reflect.Type) structFields {
 				continue
 			}
 
-			name := tag
+			name, opts := parseTag(tag)
 			if name == "" {
 				name = f.Name
 			}
 
 			idx := append(append([]int(nil), typ.index...), i)
-
-			fields[name] = field{
-				Name:  name,
-				Index: idx,
+			fld := field{
+				Name:      name,
+				Index:     idx,
+				Type:      f.Type,
+				Unit:      tagUnit(opts),
+				OmitEmpty: opts.Contains("omitempty"),
 			}
 
+			fields.all = append(fields.all, fld)
+			names = append(names, name)
+
 			typ := f.Type
 			if typ.Kind() == reflect.Ptr {
 				typ = typ.Elem()
 			}
 
-			if f.Anonymous {
+			if f.Anonymous && typ.Kind() == reflect.Struct {
 				typs = append(typs, ftyp{typ, idx})
+				continue
+			}
+
+			// embedded structs are flattened, everything else is encoded
+			// with later fields of the same name winning as they do when decoding
+			if unexp {
+				continue
+			}
+
+			if j, ok := encode[name]; ok {
+				fields.list[j] = fld
+			} else {
+				encode[name] = len(fields.list)
+				fields.list = append(fields.list, fld)
 			}
 		}
 	}
 
+	fields.names = NewFieldSet(names...)
 	return fields
 }
 
+// tagOptions are the comma separated options that follow the name in a field tag
+type tagOptions string
+
+// parseTag splits a field tag into its name and options
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+
+	return tag, ""
+}
+
+// Contains reports whether the options include name
+func (o tagOptions) Contains(name string) bool {
+	for _, opt := range strings.Split(string(o), ",") {
+		if opt == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Marshaler allows a type to define a custom marshal mechanism
 type Marshaler interface {
 	MarshalBSER() ([]byte, error)
 }
 
-// Unmarshaler allows a type to define a custom unmarshal mechanism
+// Unmarshaler allows a type to define a custom unmarshal mechanism.
+// UnmarshalBSER is passed a copy of the BSER data that it may retain,
+// unless the Decoder was set to ZeroCopy, in which case it must copy
+// the data if it wishes to retain it after returning.
 type Unmarshaler interface {
 	UnmarshalBSER([]byte) error
 }
@@ -0,0 +1,124 @@
+package bser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A capture file is a stream of PDUs: a header object identifying the
+// format, followed by an object for each Record,
+//
+//	{"format": "bser-capture", "version": 1}
+//	{"seq": 1, "time": <ns since the epoch>, "direction": "outgoing", "pdu": <string>}
+//	...
+//
+// where pdu holds the captured PDU, header included, as a byte string.
+// Capture files can be read with any BSER decoder, including the dump
+// and JSON tools.
+const (
+	captureFormat  = "bser-capture"
+	captureVersion = 1
+)
+
+// ErrNotCapture is returned by CaptureReader when its input does not
+// start with a capture file header
+var ErrNotCapture = errors.New("bser: not a capture file")
+
+type captureHeader struct {
+	Format  string `bser:"format"`
+	Version int    `bser:"version"`
+}
+
+type captureRecord struct {
+	Seq       int64     `bser:"seq"`
+	Time      time.Time `bser:"time,ns"`
+	Direction string    `bser:"direction"`
+	PDU       []byte    `bser:"pdu"`
+}
+
+// NewCaptureWriter returns a CaptureWriter writing to w
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{enc: NewEncoder(w)}
+}
+
+// CaptureWriter saves Records to a capture file. It is safe for
+// concurrent use, so WriteRecord can be called from the callback of
+// a recording Tap.
+type CaptureWriter struct {
+	mu      sync.Mutex
+	enc     *Encoder
+	started bool
+}
+
+// WriteRecord appends r to the capture file, writing the file header
+// first if it has not been written yet
+func (cw *CaptureWriter) WriteRecord(r Record) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if !cw.started {
+		if err := cw.enc.Encode(captureHeader{Format: captureFormat, Version: captureVersion}); err != nil {
+			return err
+		}
+		cw.started = true
+	}
+
+	return cw.enc.Encode(captureRecord{
+		Seq:       int64(r.Seq),
+		Time:      r.Time,
+		Direction: r.Direction.String(),
+		PDU:       r.PDU,
+	})
+}
+
+// NewCaptureReader returns a CaptureReader reading from r
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{dec: NewDecoder(r)}
+}
+
+// CaptureReader reads the Records of a capture file
+type CaptureReader struct {
+	dec     *Decoder
+	started bool
+}
+
+// ReadRecord returns the next Record of the capture file, or io.EOF
+// once all of them have been read
+func (cr *CaptureReader) ReadRecord() (Record, error) {
+	if !cr.started {
+		var h captureHeader
+		if err := cr.dec.Decode(&h); err != nil {
+			if _, ok := err.(*UnmarshalTypeError); ok {
+				return Record{}, ErrNotCapture
+			}
+			return Record{}, err
+		}
+		if h.Format != captureFormat {
+			return Record{}, ErrNotCapture
+		}
+		if h.Version != captureVersion {
+			return Record{}, fmt.Errorf("bser: unsupported capture file version %d", h.Version)
+		}
+		cr.started = true
+	}
+
+	var rec captureRecord
+	if err := cr.dec.Decode(&rec); err != nil {
+		return Record{}, err
+	}
+
+	r := Record{Seq: uint64(rec.Seq), Time: rec.Time, PDU: rec.PDU}
+	switch rec.Direction {
+	case "incoming":
+		r.Direction = Incoming
+	case "outgoing":
+		r.Direction = Outgoing
+	default:
+		return Record{}, fmt.Errorf("bser: invalid capture direction %q", rec.Direction)
+	}
+
+	return r, nil
+}
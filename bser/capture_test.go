@@ -0,0 +1,125 @@
+package bser
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordingTap(t *testing.T) {
+	var (
+		rBuf, wBuf bytes.Buffer
+		records    = make(chan Record, 2)
+	)
+
+	tap := NewRecordingTap(struct {
+		io.Reader
+		io.Writer
+	}{&rBuf, &wBuf}, func(r Record) {
+		records <- r
+	})
+	defer tap.Untap()
+
+	out, err := MarshalPDU("foo")
+	if err != nil {
+		t.Fatalf("unexpected error marshaling value: %s", err)
+	}
+	if _, err := tap.Write(out); err != nil {
+		t.Fatalf("unexpected error writing: %s", err)
+	}
+	written := <-records
+
+	in, err := MarshalPDU(42)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling value: %s", err)
+	}
+	rBuf.Write(in)
+	if _, err := ioutil.ReadAll(tap); err != nil {
+		t.Fatalf("unexpected error reading: %s", err)
+	}
+	read := <-records
+
+	if written.Seq != 1 || written.Direction != Outgoing || !bytes.Equal(written.PDU, out) {
+		t.Errorf("unexpected outgoing record %+v", written)
+	}
+	if read.Seq != 2 || read.Direction != Incoming || !bytes.Equal(read.PDU, in) {
+		t.Errorf("unexpected incoming record %+v", read)
+	}
+	if read.Time.Before(written.Time) {
+		t.Errorf("records out of order: %s before %s", read.Time, written.Time)
+	}
+
+	body, err := read.Body()
+	if err != nil || !bytes.Equal(body, []byte{0x03, 42}) {
+		t.Errorf("unexpected body %v, %v", body, err)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	records := []Record{
+		{Seq: 1, Time: time.Unix(1500000000, 1), Direction: Outgoing, PDU: mustMarshalPDU([]string{"version"})},
+		{Seq: 2, Time: time.Unix(1500000001, 2), Direction: Incoming, PDU: mustMarshalPDU(map[string]string{"version": "4.9.0"})},
+	}
+
+	var buf bytes.Buffer
+	cw := NewCaptureWriter(&buf)
+	for _, r := range records {
+		if err := cw.WriteRecord(r); err != nil {
+			t.Fatalf("unexpected error writing record: %s", err)
+		}
+	}
+
+	// the capture file is itself plain BSER
+	if err := ToJSON(ioutil.Discard, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unexpected error converting to JSON: %s", err)
+	}
+
+	cr := NewCaptureReader(&buf)
+	for i, expected := range records {
+		actual, err := cr.ReadRecord()
+		if err != nil {
+			t.Fatalf("unexpected error reading record %d: %s", i, err)
+		}
+		if !actual.Time.Equal(expected.Time) {
+			t.Fatalf("unexpected time %s, expected %s", actual.Time, expected.Time)
+		}
+		actual.Time = expected.Time
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("unexpected record:\n\nexpected = %+v\n\nactual = %+v", expected, actual)
+		}
+	}
+
+	if _, err := cr.ReadRecord(); err != io.EOF {
+		t.Fatalf("expected EOF, found %v", err)
+	}
+}
+
+func TestCaptureReaderInvalid(t *testing.T) {
+	var captureReaderTests = map[string]struct {
+		data []byte
+		err  string
+	}{
+		"not_object":   {data: mustMarshalPDU(1), err: ErrNotCapture.Error()},
+		"wrong_format": {data: mustMarshalPDU(map[string]interface{}{"format": "x", "version": 1}), err: ErrNotCapture.Error()},
+		"version":      {data: mustMarshalPDU(captureHeader{Format: captureFormat, Version: 2}), err: "bser: unsupported capture file version 2"},
+		"direction": {
+			data: append(
+				mustMarshalPDU(captureHeader{Format: captureFormat, Version: captureVersion}),
+				mustMarshalPDU(captureRecord{Seq: 1, Direction: "sideways"})...,
+			),
+			err: `bser: invalid capture direction "sideways"`,
+		},
+	}
+
+	for testName, testCase := range captureReaderTests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := NewCaptureReader(bytes.NewReader(testCase.data)).ReadRecord()
+			if err == nil || err.Error() != testCase.err {
+				t.Fatalf("expected error %q, found %v", testCase.err, err)
+			}
+		})
+	}
+}
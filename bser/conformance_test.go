@@ -0,0 +1,147 @@
+package bser
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// conformanceDir holds PDUs encoded by pywatchman and the watchman
+// server, each with the JSON it decodes to. corpus.json lists them and
+// marks those the package must reproduce byte for byte. generate.py
+// generates them; see the README there.
+const conformanceDir = "testdata/conformance"
+
+type conformanceCase struct {
+	Name        string `json:"name"`
+	Source      string `json:"source"`
+	Description string `json:"description"`
+	// Canonical cases are encoded by AppendFromJSON exactly as the
+	// reference implementation encoded them
+	Canonical bool `json:"canonical"`
+	// GeneratedBy is the implementation and version generate.py
+	// produced the case with
+	GeneratedBy string `json:"generated_by"`
+}
+
+func TestConformance(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join(conformanceDir, "corpus.json"))
+	if err != nil {
+		t.Fatalf("unexpected error reading corpus: %s", err)
+	}
+
+	var corpus []conformanceCase
+	if err := json.Unmarshal(b, &corpus); err != nil {
+		t.Fatalf("unexpected error parsing corpus: %s", err)
+	}
+
+	if len(corpus) == 0 {
+		t.Skip("the conformance corpus has not been generated; run testdata/conformance/generate.py")
+	}
+
+	for _, testCase := range corpus {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			// a case that wasn't produced by the reference
+			// implementation only checks the package against itself
+			if testCase.GeneratedBy == "" {
+				t.Fatalf("%s has no generated_by: it was not produced by %s with generate.py", testCase.Name, testCase.Source)
+			}
+			testConformance(t, testCase)
+		})
+	}
+}
+
+func testConformance(t *testing.T, testCase conformanceCase) {
+	pdu, err := ioutil.ReadFile(filepath.Join(conformanceDir, testCase.Name+".bser"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	js, err := ioutil.ReadFile(filepath.Join(conformanceDir, testCase.Name+".json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var expected bytes.Buffer
+	if err := json.Compact(&expected, js); err != nil {
+		t.Fatalf("unexpected error compacting JSON: %s", err)
+	}
+
+	// the file is a single PDU
+	r := NewPDUReader(bytes.NewReader(pdu))
+	body, err := r.ReadPDU(nil)
+	if err != nil {
+		t.Fatalf("unexpected error reading PDU: %s", err)
+	}
+	if _, err := r.ReadPDU(nil); err != io.EOF {
+		t.Fatalf("expected a single PDU, found %v", err)
+	}
+
+	actual, err := AppendJSON(nil, body)
+	if err != nil {
+		t.Fatalf("unexpected error converting to JSON: %s", err)
+	}
+	if !bytes.Equal(expected.Bytes(), actual) {
+		t.Fatalf("unexpected JSON:\n\nexpected = %s\n\nactual = %s", expected.Bytes(), actual)
+	}
+
+	// encoding the JSON gives a value that converts back to the same JSON
+	encoded, err := AppendFromJSON(nil, actual)
+	if err != nil {
+		t.Fatalf("unexpected error converting from JSON: %s", err)
+	}
+	if testCase.Canonical && !bytes.Equal(body, encoded) {
+		t.Fatalf("unexpected encoding:\n\nexpected = %v\n\nactual = %v", body, encoded)
+	}
+	if again, err := AppendJSON(nil, encoded); err != nil || !bytes.Equal(actual, again) {
+		t.Fatalf("unexpected JSON round trip: %s %v", again, err)
+	}
+
+	// the reflection based decoder and encoder preserve the value,
+	// though not the order of object keys
+	var v interface{}
+	if err := NewDecoder(bytes.NewReader(pdu)).Decode(&v); err != nil {
+		t.Fatalf("unexpected error decoding: %s", err)
+	}
+
+	marshaled, err := MarshalValue(v)
+	if err != nil {
+		t.Fatalf("unexpected error encoding: %s", err)
+	}
+
+	roundTrip, err := AppendJSON(nil, marshaled)
+	if err != nil {
+		t.Fatalf("unexpected error converting to JSON: %s", err)
+	}
+	if !jsonEqual(t, actual, roundTrip) {
+		t.Fatalf("unexpected decode/encode round trip:\n\nexpected = %s\n\nactual = %s", actual, roundTrip)
+	}
+
+	if err := Dump(ioutil.Discard, pdu); err != nil {
+		t.Fatalf("unexpected error dumping: %s", err)
+	}
+}
+
+// jsonEqual reports whether a and b hold the same JSON value,
+// comparing numbers as written
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	parse := func(js []byte) interface{} {
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.UseNumber()
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", js, err)
+		}
+		return v
+	}
+
+	return reflect.DeepEqual(parse(a), parse(b))
+}
@@ -0,0 +1,244 @@
+package bser
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"unicode/utf8"
+)
+
+// Cursor reads BSER values one at a time from an encoded buffer.
+// The Read methods accept a null in place of the requested type and
+// return the zero value, as the reflection based decoder does.
+type Cursor struct {
+	d decodeState
+}
+
+// NewCursor returns a Cursor positioned at the start of b
+func NewCursor(b []byte) *Cursor {
+	return &Cursor{d: decodeState{data: b}}
+}
+
+// Offset returns the number of bytes consumed so far
+func (c *Cursor) Offset() int {
+	return c.d.off
+}
+
+// Peek returns the type marker of the next value without consuming it
+func (c *Cursor) Peek() (Type, error) {
+	m, err := c.d.peek()
+	return Type(m), err
+}
+
+// Missing consumes the next value and reports true if it is the
+// missing value marker found in templated arrays
+func (c *Cursor) Missing() bool {
+	if m, err := c.d.peek(); err == nil && Type(m) == TypeMissing {
+		c.d.off++
+		return true
+	}
+
+	return false
+}
+
+// Null consumes the next value and reports true if it is null
+func (c *Cursor) Null() bool {
+	if m, err := c.d.peek(); err == nil && Type(m) == TypeNull {
+		c.d.off++
+		return true
+	}
+
+	return false
+}
+
+// Skip consumes the next value
+func (c *Cursor) Skip() error {
+	return c.d.value(emptyValue)
+}
+
+// Decode decodes the next value into dest using the reflection based decoder
+func (c *Cursor) Decode(dest interface{}) error {
+	return c.d.value(reflect.ValueOf(dest))
+}
+
+// ReadString reads a string value
+func (c *Cursor) ReadString() (string, error) {
+	if c.Null() {
+		return "", nil
+	}
+
+	off := c.d.off
+	b, err := c.d.stringBytes()
+	if err != nil {
+		return "", err
+	}
+
+	if c.d.validateUTF8 && !utf8.Valid(b) {
+		return "", c.d.syntaxError(off, "invalid UTF-8 in string")
+	}
+
+	return string(b), nil
+}
+
+// ReadBytes reads a string value as a copy of its raw bytes, without
+// assuming it is valid UTF-8. Null reads as nil.
+func (c *Cursor) ReadBytes() ([]byte, error) {
+	if c.Null() {
+		return nil, nil
+	}
+
+	b, err := c.d.stringBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(make([]byte, 0, len(b)), b...), nil
+}
+
+// ReadKey reads an object key. The returned bytes alias the
+// underlying buffer and are only valid until it is modified.
+func (c *Cursor) ReadKey() ([]byte, error) {
+	return c.d.stringBytes()
+}
+
+// ReadInt reads an integer value of any width
+func (c *Cursor) ReadInt() (int64, error) {
+	if c.Null() {
+		return 0, nil
+	}
+
+	m, err := c.d.marker()
+	if err != nil {
+		return 0, err
+	}
+	c.d.m = m
+
+	if Type(m) < TypeInt8 || Type(m) > TypeInt64 {
+		return 0, c.d.typeError(c.d.off-1, typInt64)
+	}
+
+	return c.d.int(m)
+}
+
+// ReadReal reads a real value
+func (c *Cursor) ReadReal() (float64, error) {
+	if c.Null() {
+		return 0, nil
+	}
+
+	m, err := c.d.marker()
+	if err != nil {
+		return 0, err
+	}
+	c.d.m = m
+
+	if Type(m) != TypeReal {
+		return 0, c.d.typeError(c.d.off-1, typFloat64)
+	}
+
+	b, err := c.d.next(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(order.Uint64(b)), nil
+}
+
+// ReadBool reads a boolean value
+func (c *Cursor) ReadBool() (bool, error) {
+	if c.Null() {
+		return false, nil
+	}
+
+	m, err := c.d.marker()
+	if err != nil {
+		return false, err
+	}
+	c.d.m = m
+
+	switch Type(m) {
+	case TypeTrue:
+		return true, nil
+	case TypeFalse:
+		return false, nil
+	default:
+		return false, c.d.typeError(c.d.off-1, typBool)
+	}
+}
+
+// ReadArrayHeader reads the header of an array and returns its length
+func (c *Cursor) ReadArrayHeader() (int, error) {
+	return c.header(TypeArray)
+}
+
+// ReadObjectHeader reads the header of an object and returns its number of fields
+func (c *Cursor) ReadObjectHeader() (int, error) {
+	return c.header(TypeObject)
+}
+
+// ReadTemplateHeader reads the header of a templated array and
+// returns its field names and number of rows
+func (c *Cursor) ReadTemplateHeader() ([]string, int, error) {
+	if err := c.expect(TypeTemplate); err != nil {
+		return nil, 0, err
+	}
+
+	n, err := c.ReadArrayHeader()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	names := make([]string, n)
+	for i := range names {
+		if names[i], err = c.ReadString(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	rows, err := c.d.rows(len(names))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return names, rows, nil
+}
+
+// DisallowUnknownFields causes UnknownField to return an error
+// instead of skipping the value
+func (c *Cursor) DisallowUnknownFields() {
+	c.d.disallowUnknownFields = true
+}
+
+// ValidateUTF8 causes ReadString to return an error for strings
+// that are not valid UTF-8
+func (c *Cursor) ValidateUTF8() {
+	c.d.validateUTF8 = true
+}
+
+// UnknownField handles the value of an object key that did not match
+// any field. The value is skipped unless DisallowUnknownFields was called.
+func (c *Cursor) UnknownField(name []byte) error {
+	return c.d.unknownField(name, nil)
+}
+
+func (c *Cursor) header(t Type) (int, error) {
+	if err := c.expect(t); err != nil {
+		return 0, err
+	}
+
+	return c.d.count()
+}
+
+func (c *Cursor) expect(t Type) error {
+	m, err := c.d.marker()
+	if err != nil {
+		return err
+	}
+	c.d.m = m
+
+	if Type(m) != t {
+		return c.d.syntaxError(c.d.off-1, fmt.Sprintf("expected %s, found %s", t, Type(m)))
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package bser
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAppendMatchesEncoder(t *testing.T) {
+	var appendTests = map[string]struct {
+		appended []byte
+		value    interface{}
+	}{
+		"null":     {AppendNull(nil), nil},
+		"true":     {AppendBool(nil, true), true},
+		"false":    {AppendBool(nil, false), false},
+		"string":   {AppendString(nil, "hello"), "hello"},
+		"int_fit":  {AppendInt(nil, 100001), 100001},
+		"int8":     {AppendInt8(nil, -3), int8(-3)},
+		"int16":    {AppendInt16(nil, 3), int16(3)},
+		"int32":    {AppendInt32(nil, 3), int32(3)},
+		"int64":    {AppendInt64(nil, 3), int64(3)},
+		"real":     {AppendReal(nil, 0.5), 0.5},
+		"array":    {AppendString(AppendString(AppendArrayHeader(nil, 2), "a"), "b"), []string{"a", "b"}},
+		"object":   {AppendInt(AppendString(AppendObjectHeader(nil, 1), "Age"), 20), struct{ Age int }{20}},
+		"template": {AppendInt(AppendString(AppendTemplateHeader(nil, []string{"Name", "Age"}, 1), "fred"), 20), []person{{Name: "fred", Age: 20}}},
+	}
+
+	for testName, testCase := range appendTests {
+		t.Run(testName, func(t *testing.T) {
+			expected, err := MarshalValue(testCase.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !bytes.Equal(expected, testCase.appended) {
+				t.Fatalf("unexpected encoded data:\n\nexpected = %v\n\nactual = %v", expected, testCase.appended)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	var b []byte
+	b = AppendTemplateHeader(b, []string{"name", "size"}, 2)
+	b = AppendString(b, "a")
+	b = AppendInt(b, 1000)
+	b = append(b, byte(TypeMissing))
+	b = AppendNull(b)
+
+	c := NewCursor(b)
+	names, rows, err := c.ReadTemplateHeader()
+	if err != nil {
+		t.Fatalf("unexpected error reading template header: %s", err)
+	}
+	if len(names) != 2 || names[0] != "name" || names[1] != "size" || rows != 2 {
+		t.Fatalf("unexpected template header: %v %d", names, rows)
+	}
+
+	if s, err := c.ReadString(); err != nil || s != "a" {
+		t.Fatalf("unexpected string: %q %v", s, err)
+	}
+	if n, err := c.ReadInt(); err != nil || n != 1000 {
+		t.Fatalf("unexpected int: %d %v", n, err)
+	}
+	if !c.Missing() {
+		t.Fatal("expected missing value")
+	}
+	if n, err := c.ReadInt(); err != nil || n != 0 {
+		t.Fatalf("expected null to read as zero: %d %v", n, err)
+	}
+	if c.Offset() != len(b) {
+		t.Fatalf("expected all %d bytes to be consumed, found %d", len(b), c.Offset())
+	}
+
+	if _, err := NewCursor(AppendString(nil, "a")).ReadInt(); err == nil {
+		t.Fatal("expected error reading string as int")
+	}
+}
+
+func TestCursorUnknownField(t *testing.T) {
+	b := AppendInt(AppendArrayHeader(nil, 1), 1)
+	b = AppendBool(b, true)
+
+	c := NewCursor(b)
+	if err := c.UnknownField([]byte("extra")); err != nil {
+		t.Fatalf("unexpected error skipping unknown field: %s", err)
+	}
+	if v, err := c.ReadBool(); err != nil || !v {
+		t.Fatalf("unexpected value after skipped field: %v %v", v, err)
+	}
+
+	c = NewCursor(b)
+	c.DisallowUnknownFields()
+	expected := &UnknownFieldError{Name: "extra", Offset: 0}
+	if err := c.UnknownField([]byte("extra")); !reflect.DeepEqual(err, expected) {
+		t.Fatalf("unexpected error:\n\nexpected = %#v\n\nactual = %#v", expected, err)
+	}
+}
+
+func TestCursorStringError(t *testing.T) {
+	c := NewCursor([]byte("\x0d\x03\x02\xff\xfe"))
+	c.ValidateUTF8()
+
+	// the error gives the marker of the string, a UTF-8 string here
+	expected := &SyntaxError{msg: "invalid UTF-8 in string", Offset: 0, Type: TypeUTF8String}
+	if _, err := c.ReadString(); !reflect.DeepEqual(err, expected) {
+		t.Fatalf("unexpected error:\n\nexpected = %#v\n\nactual = %#v", expected, err)
+	}
+}
+
+func TestCursorBytes(t *testing.T) {
+	var b []byte
+	b = AppendBytes(b, []byte("a\xffb"))
+	b = AppendBytes(b, nil)
+	b = AppendString(b, "a\xffb")
+
+	c := NewCursor(b)
+	if v, err := c.ReadBytes(); err != nil || string(v) != "a\xffb" {
+		t.Fatalf("unexpected bytes: %q %v", v, err)
+	}
+	if v, err := c.ReadBytes(); err != nil || v != nil {
+		t.Fatalf("expected null to read as nil: %q %v", v, err)
+	}
+
+	c.ValidateUTF8()
+	if _, err := c.ReadString(); err == nil {
+		t.Fatal("unexpectedly no error for invalid UTF-8")
+	}
+}
@@ -2,16 +2,22 @@ package bser
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"unicode/utf8"
+	"unsafe"
 )
 
 var (
 	emptyValue = reflect.Value{}
 )
 
+// defaultMaxDepth is the nesting limit used when none is set
+const defaultMaxDepth = 10000
+
 // UnmarshalPDU unmarshal b into dest
 func UnmarshalPDU(b []byte, dest interface{}) error {
 	return NewDecoder(bytes.NewReader(b)).Decode(dest)
@@ -19,462 +25,838 @@ func UnmarshalPDU(b []byte, dest interface{}) error {
 
 // UnmarshalValue unmarshal b into dest
 func UnmarshalValue(b []byte, dest interface{}) error {
-	return decodeValue(bytes.NewReader(b), reflect.ValueOf(dest), nil)
+	d := decodeState{data: b}
+	return d.value(reflect.ValueOf(dest))
 }
 
 // NewDecoder returns an initialized Decoder
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r}
+	return NewPDUDecoder(NewPDUReader(r))
+}
+
+// NewPDUDecoder returns a Decoder that reads PDUs from r
+func NewPDUDecoder(r *PDUReader) *Decoder {
+	return &Decoder{r: r}
 }
 
 // A Decoder reads and decodes BSER values from an input stream
 type Decoder struct {
-	r io.Reader
+	r    *PDUReader
+	buf  []byte
+	opts decodeOptions
+}
+
+// SetMaxPDUSize limits the PDUs the Decoder reads to n bytes,
+// as PDUReader.SetMaxSize does
+func (d *Decoder) SetMaxPDUSize(n int) {
+	d.r.SetMaxSize(n)
+}
+
+// SetMaxDepth limits the nesting of arrays and objects to n levels.
+// A value of 0 restores the default of 10000.
+func (d *Decoder) SetMaxDepth(n int) {
+	d.opts.maxDepth = n
+}
+
+// SetMaxElements limits the number of values in any single array,
+// object or templated array to n. A value of 0 means no limit.
+func (d *Decoder) SetMaxElements(n int) {
+	d.opts.maxElements = n
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when an
+// object key does not match any field of the destination struct. By
+// default such keys are skipped. It does not apply to values decoded
+// by an Unmarshaler.
+func (d *Decoder) DisallowUnknownFields() {
+	d.opts.disallowUnknownFields = true
+}
+
+// ValidateUTF8 causes the Decoder to return an error when a string
+// decoded into a Go string is not valid UTF-8. Strings decoded into
+// []byte are never checked, as watchman reports file names as they are
+// found on disk. It does not apply to values decoded by an Unmarshaler.
+func (d *Decoder) ValidateUTF8() {
+	d.opts.validateUTF8 = true
+}
+
+// ZeroCopy causes the Decoder to store strings, []byte and RawMessage
+// values that point into its buffer rather than copies of their data.
+// This saves an allocation per value, but the values are only valid
+// until the next call to Decode, which reuses the buffer: anything kept
+// longer must be copied first. Unmarshalers are also passed data in the
+// buffer rather than a copy of it, so they must copy what they keep.
+func (d *Decoder) ZeroCopy() {
+	d.opts.zeroCopy = true
 }
 
 // Decode reads the next BSER-encoded value from its
 // input and stores it in the value pointed to by dest.
 func (d *Decoder) Decode(dest interface{}) error {
-	buf, err := readPDU(d.r)
+	buf, err := d.r.ReadPDU(d.buf[:0])
 	if err != nil {
 		return err
 	}
 
-	return decodeValue(bytes.NewReader(buf), reflect.ValueOf(dest), nil)
+	d.buf = buf
+	ds := decodeState{data: buf, decodeOptions: d.opts}
+	return ds.value(reflect.ValueOf(dest))
+}
+
+// decodeOptions are the limits and modes set on a Decoder
+type decodeOptions struct {
+	maxDepth              int
+	maxElements           int
+	disallowUnknownFields bool
+	validateUTF8          bool
+	zeroCopy              bool
+}
+
+// decodeState walks an in-memory BSER value
+type decodeState struct {
+	data  []byte
+	off   int
+	m     byte // marker of the value being decoded, for errors
+	depth int
+	decodeOptions
+}
+
+// syntaxError reports the error msg found at off. d.m, the marker of
+// the value being read, stands in for the byte at off past the end.
+func (d *decodeState) syntaxError(off int, msg string) error {
+	t := Type(d.m)
+	if off >= 0 && off < len(d.data) {
+		t = Type(d.data[off])
+	}
+
+	return &SyntaxError{msg: msg, Offset: off, Type: t}
+}
+
+// typeError reports that the value whose marker is at off can't be stored in t
+func (d *decodeState) typeError(off int, t reflect.Type) error {
+	return &UnmarshalTypeError{Value: Type(d.data[off]), Type: t, Offset: off}
+}
+
+// next returns the next n bytes
+func (d *decodeState) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, d.syntaxError(len(d.data), "unexpected end of data")
+	}
+
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decodeState) marker() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+func (d *decodeState) peek() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, io.EOF
+	}
+
+	return d.data[d.off], nil
+}
+
+// int reads the integer value that follows marker m
+func (d *decodeState) int(m byte) (int64, error) {
+	switch m {
+	case 0x03:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int8(b[0])), nil
+	case 0x04:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int16(order.Uint16(b))), nil
+	case 0x05:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int32(order.Uint32(b))), nil
+	case 0x06:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, err
+		}
+		return int64(order.Uint64(b)), nil
+	default:
+		return 0, &SyntaxError{msg: fmt.Sprintf("invalid integer marker %x", m), Offset: d.off - 1, Type: Type(m)}
+	}
+}
+
+// length reads an integer marker and value used as a length or count
+func (d *decodeState) length() (int, error) {
+	m, err := d.marker()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := d.int(m)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 || n > int64(len(d.data)) {
+		return 0, d.syntaxError(d.off-intSize(m)-1, fmt.Sprintf("invalid length %d", n))
+	}
+
+	return int(n), nil
+}
+
+// count reads the number of values in an array, object or templated
+// array. Each value takes at least a byte, so a count larger than the
+// data left is rejected before anything is allocated for it.
+func (d *decodeState) count() (int, error) {
+	off := d.off
+	n, err := d.length()
+	if err != nil {
+		return 0, err
+	}
+
+	if d.maxElements > 0 && n > d.maxElements {
+		return 0, d.syntaxError(off, fmt.Sprintf("exceeded max element count %d", d.maxElements))
+	}
+
+	if n > len(d.data)-d.off {
+		return 0, d.syntaxError(off, fmt.Sprintf("count %d exceeds the %d bytes left", n, len(d.data)-d.off))
+	}
+
+	return n, nil
 }
 
-func decodeValue(r io.Reader, dest reflect.Value, buf *[]byte) error {
+// rows reads the number of rows of a templated array with width field
+// names. Each row takes at least a byte per field, and a template
+// without field names is rejected, as its rows would take no data.
+func (d *decodeState) rows(width int) (int, error) {
+	off := d.off
+	n, err := d.count()
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 && width == 0 {
+		return 0, d.syntaxError(off, "templated array has rows but no field names")
+	}
+	if width > 0 && n > (len(d.data)-d.off)/width {
+		return 0, d.syntaxError(off, fmt.Sprintf("%d rows of %d fields exceed the %d bytes left", n, width, len(d.data)-d.off))
+	}
+
+	return n, nil
+}
+
+// makeSlice returns a slice of type t for n values. At most
+// preallocLimit bytes are allocated up front, and growSlice extends
+// it as values are decoded, so the allocation follows the data.
+func makeSlice(t reflect.Type, n int) reflect.Value {
+	if size := int(t.Elem().Size()); size > 0 && n > preallocLimit/size {
+		n = preallocLimit / size
+	}
+
+	return reflect.MakeSlice(t, n, n)
+}
+
+// growSlice returns s, made long enough for value i of n if it isn't
+func growSlice(s reflect.Value, i, n int) reflect.Value {
+	if i < s.Len() {
+		return s
+	}
+
+	l := 2 * s.Len()
+	if l <= i {
+		l = i + 1
+	}
+	if l > n {
+		l = n
+	}
+
+	grown := reflect.MakeSlice(s.Type(), l, l)
+	reflect.Copy(grown, s)
+	return grown
+}
+
+// push enters a nested array or object whose marker is at off. The
+// matching pop is only needed on success, as errors abort the decode.
+func (d *decodeState) push(off int) error {
+	d.depth++
+
+	max := d.maxDepth
+	if max <= 0 {
+		max = defaultMaxDepth
+	}
+
+	if d.depth > max {
+		return d.syntaxError(off, fmt.Sprintf("exceeded max depth %d", max))
+	}
+
+	return nil
+}
+
+func (d *decodeState) pop() {
+	d.depth--
+}
+
+// unknownField handles the value of an object key that did not match
+// any field of t, which is nil if it isn't known
+func (d *decodeState) unknownField(key []byte, t reflect.Type) error {
+	if d.disallowUnknownFields {
+		return d.unknownFieldError(d.off, string(key), t)
+	}
+
+	return d.value(emptyValue)
+}
+
+// unknownFieldError reports that name, whose value or templated array
+// is at off, matched no field of t
+func (d *decodeState) unknownFieldError(off int, name string, t reflect.Type) error {
+	return &UnknownFieldError{Name: name, Type: t, Offset: off}
+}
+
+// stringBytes reads a string marker and returns the raw string bytes
+func (d *decodeState) stringBytes() ([]byte, error) {
+	m, err := d.marker()
+	if err != nil {
+		return nil, err
+	}
+
+	if m != 0x02 && m != 0x0d {
+		return nil, d.typeError(d.off-1, typString)
+	}
+
+	return d.stringBody()
+}
+
+// key reads an object key
+func (d *decodeState) key() ([]byte, error) {
+	d.m = 0x01
+	m, err := d.marker()
+	if err != nil {
+		return nil, err
+	}
+
+	if m != 0x02 && m != 0x0d {
+		return nil, d.syntaxError(d.off-1, fmt.Sprintf("object key must be a string, found %s", Type(m)))
+	}
+
+	return d.stringBody()
+}
+
+func (d *decodeState) stringBody() ([]byte, error) {
+	size, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.next(size)
+}
+
+func (d *decodeState) value(dest reflect.Value) error {
 	if dest != emptyValue && dest.Kind() != reflect.Ptr {
 		return fmt.Errorf("Invalid dest passed in. Expected ptr, found: %s", dest.Kind())
 	}
 
-	var (
-		u      Unmarshaler
-		err    error
-		offset = 0
-	)
+	if m, err := d.peek(); err == nil && m == 0x0c {
+		// 0x0c is used to represent missing value in templated array - skipping to use default value
+		d.off++
+		return nil
+	}
 
 	if dest != emptyValue && dest.Type().Implements(typUnmarshaler) {
-		u = dest.Interface().(Unmarshaler)
-		if buf == nil {
-			buf = &[]byte{}
-			offset = 0
-		} else {
-			offset = len(*buf)
+		start := d.off
+		if err := d.value(emptyValue); err != nil {
+			return err
 		}
-		dest = emptyValue
+
+		b := d.data[start:d.off:d.off]
+		if d.zeroCopy && dest.Type() == typRawMessagePtr {
+			dest.Elem().SetBytes(b)
+			return nil
+		}
+
+		// a Decoder reuses its buffer, so unless ZeroCopy was asked for
+		// the Unmarshaler gets a copy it may keep. RawMessage copies
+		// the data itself.
+		if !d.zeroCopy && dest.Type() != typRawMessagePtr {
+			b = append([]byte(nil), b...)
+		}
+
+		return addOffset(dest.Interface().(Unmarshaler).UnmarshalBSER(b), start)
 	}
 
 	if dest != emptyValue {
+		switch dest.Type().Elem() {
+		case typTime, typDuration:
+			return d.timeValue(dest.Elem(), 0)
+		}
+
+		if m, _ := d.peek(); (m == 0x02 || m == 0x0d) && dest.Type().Implements(typTextUnmarshaler) {
+			return d.text(dest)
+		}
+
 		dest = dest.Elem()
-	}
 
-	l := make([]byte, 1)
-	if _, err := r.Read(l); err != nil {
-		return err
+		// pointers are allocated for anything but null, which clears them
+		if dest.Kind() == reflect.Ptr {
+			if m, _ := d.peek(); m != 0x0a {
+				if dest.IsNil() {
+					dest.Set(reflect.New(dest.Type().Elem()))
+				}
+				return d.value(dest)
+			}
+		}
 	}
 
-	if buf != nil {
-		*buf = append(*buf, l...)
+	m, err := d.marker()
+	if err != nil {
+		return err
 	}
+	d.m = m
 
-	switch l[0] {
+	switch m {
 	case 0x00:
-		err = decodeArray(r, dest, buf)
+		return d.array(dest)
 	case 0x01:
-		err = decodeObject(r, dest, buf)
-	case 0x02:
-		err = decodeString(r, dest, buf)
+		return d.object(dest)
+	case 0x02, 0x0d:
+		return d.string(dest)
 	case 0x03:
-		err = decodeInt8(r, dest, buf)
+		return d.integer(m, dest, typInt8)
 	case 0x04:
-		err = decodeInt16(r, dest, buf)
+		return d.integer(m, dest, typInt16)
 	case 0x05:
-		err = decodeInt32(r, dest, buf)
+		return d.integer(m, dest, typInt32)
 	case 0x06:
-		err = decodeInt64(r, dest, buf)
+		return d.integer(m, dest, typInt64)
 	case 0x07:
-		err = decodeReal(r, dest, buf)
+		return d.real(dest)
 	case 0x08:
 		if dest != emptyValue {
-			err = decodeBool(true, dest)
+			return d.bool(true, dest)
 		}
 	case 0x09:
 		if dest != emptyValue {
-			err = decodeBool(false, dest)
+			return d.bool(false, dest)
 		}
 	case 0x0a:
 		if dest != emptyValue {
-			dest.Set(reflect.ValueOf(nil))
+			dest.Set(reflect.Zero(dest.Type()))
 		}
 	case 0x0b:
-		err = decodeTemplate(r, dest, buf)
-	case 0x0c:
-		// 0x0c is used to represent missing value in templated array - skipping to use default value
-		return nil
+		return d.template(dest)
 	default:
-		err = fmt.Errorf("Invalid type marker found: %x", l[0])
+		return d.syntaxError(d.off-1, fmt.Sprintf("invalid type marker %x", m))
+	}
+
+	return nil
+}
+
+// field decodes the next value into dest, a pointer to the struct
+// field f, applying the unit from its tag to time fields and
+// pointers to them
+func (d *decodeState) field(dest reflect.Value, f field) error {
+	if f.Unit != 0 && isTime(f.Type) {
+		return d.unitValue(dest, f.Unit)
 	}
 
-	if err == nil && u != nil {
-		err = u.UnmarshalBSER((*buf)[offset:])
+	return d.value(dest)
+}
+
+// text decodes a string into dest, which implements encoding.TextUnmarshaler
+func (d *decodeState) text(dest reflect.Value) error {
+	start := d.off
+	b, err := d.stringBytes()
+	if err != nil {
+		return err
 	}
 
-	return err
+	if dest.IsNil() {
+		return d.typeError(start, dest.Type())
+	}
+
+	return dest.Interface().(encoding.TextUnmarshaler).UnmarshalText(b)
 }
 
-func decodeArray(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typGenericSlice)
+func (d *decodeState) array(dest reflect.Value) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typGenericSlice)
 	if err != nil {
 		return err
 	}
 
 	if dest != emptyValue && dest.Kind() != reflect.Slice && dest.Kind() != reflect.Array {
-		return fmt.Errorf("Expected slice or array, found %s", dest.Kind())
+		return d.typeError(off, dest.Type())
+	}
+
+	if err := d.push(off); err != nil {
+		return err
 	}
 
-	var length int
-	if err = decodeValue(r, reflect.ValueOf(&length), buf); err != nil {
+	length, err := d.count()
+	if err != nil {
 		return err
 	}
 
-	if dest != emptyValue && dest.Kind() == reflect.Slice {
-		dest.Set(reflect.MakeSlice(dest.Type(), length, length))
+	isSlice := dest != emptyValue && dest.Kind() == reflect.Slice
+	if isSlice {
+		dest.Set(makeSlice(dest.Type(), length))
 	}
 
 	for i := 0; i < length; i++ {
-		var (
-			noset = dest == emptyValue || i >= dest.Len()
-			v     = emptyValue
-		)
-
-		if !noset {
-			v = reflect.New(dest.Type().Elem())
+		if isSlice {
+			dest.Set(growSlice(dest, i, length))
 		}
 
-		if err := decodeValue(r, v, buf); err != nil {
-			return err
+		v := emptyValue
+		if dest != emptyValue && i < dest.Len() {
+			v = dest.Index(i).Addr()
 		}
 
-		if !noset {
-			dest.Index(i).Set(v.Elem())
+		if err := d.value(v); err != nil {
+			return addPath(err, indexPath(i))
 		}
 	}
 
+	d.pop()
 	return nil
 }
 
-func decodeObject(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typGenericMap)
+func (d *decodeState) object(dest reflect.Value) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typGenericMap)
 	if err != nil {
 		return err
 	}
+
+	if err := d.push(off); err != nil {
+		return err
+	}
+
 	switch k := dest.Kind(); k {
 	case reflect.Invalid:
-		if buf == nil {
-			panic("should only get reflect.Invalid if buf is non-nil")
-		}
-		var fields int
-		if err := decodeValue(r, reflect.ValueOf(&fields), buf); err != nil {
+		fields, err := d.count()
+		if err != nil {
 			return err
 		}
 		for i := 0; i < fields; i++ {
-			if err := decodeValue(r, emptyValue, buf); err != nil {
+			key, err := d.key()
+			if err != nil {
 				return err
 			}
 
-			if err := decodeValue(r, emptyValue, buf); err != nil {
-				return err
+			if err := d.value(emptyValue); err != nil {
+				return addPath(err, string(key))
 			}
 		}
 	case reflect.Map:
 		if dest.Type().Key().Kind() != reflect.String {
-			return fmt.Errorf("Map must have key type of string, found: %s", dest.Type().Key())
+			return d.typeError(off, dest.Type())
 		}
 
-		var fields int
-		if err := decodeValue(r, reflect.ValueOf(&fields), buf); err != nil {
+		fields, err := d.count()
+		if err != nil {
 			return err
 		}
 
+		if dest.IsNil() {
+			dest.Set(reflect.MakeMapWithSize(dest.Type(), fields))
+		}
+
+		var (
+			keyType  = dest.Type().Key()
+			elemType = dest.Type().Elem()
+		)
+
 		for i := 0; i < fields; i++ {
-			var field string
-			fieldV := reflect.ValueOf(&field)
-			if err := decodeValue(r, fieldV, buf); err != nil {
+			key, err := d.key()
+			if err != nil {
 				return err
 			}
 
-			v := reflect.New(dest.Type().Elem())
-			if err := decodeValue(r, v, buf); err != nil {
-				return err
+			v := reflect.New(elemType)
+			if err := d.value(v); err != nil {
+				return addPath(err, string(key))
 			}
-			dest.SetMapIndex(fieldV.Elem(), v.Elem())
+			dest.SetMapIndex(reflect.ValueOf(string(key)).Convert(keyType), v.Elem())
 		}
 	case reflect.Struct:
 		tfields := fields(dest.Type())
-		var fields int
-		if err := decodeValue(r, reflect.ValueOf(&fields), buf); err != nil {
+		fields, err := d.count()
+		if err != nil {
 			return err
 		}
 
 		for i := 0; i < fields; i++ {
-			var field string
-			if err := decodeValue(r, reflect.ValueOf(&field), buf); err != nil {
+			key, err := d.key()
+			if err != nil {
 				return err
 			}
 
-			f, ok := tfields.field(field)
+			f, ok := tfields.field(key)
 			if !ok {
-				return fmt.Errorf("Field %s not found", field)
+				if err := d.unknownField(key, dest.Type()); err != nil {
+					return err
+				}
+				continue
 			}
 
-			if err := decodeValue(r, fieldByIndex(dest, f.Index).Addr(), buf); err != nil {
-				return err
+			if err := d.field(fieldByIndex(dest, f.Index).Addr(), f); err != nil {
+				return addPath(err, string(key))
 			}
 		}
 	default:
-		return fmt.Errorf("Expected struct or map, found %s", k)
+		return d.typeError(off, dest.Type())
 	}
+
+	d.pop()
 	return nil
 }
 
-func decodeString(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typString)
+func (d *decodeState) string(dest reflect.Value) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typString)
 	if err != nil {
 		return err
 	}
 
-	var size int
-	if err := decodeValue(r, reflect.ValueOf(&size), buf); err != nil {
-		return err
-	}
-
-	b := make([]byte, size)
-	if _, err := r.Read(b); err != nil {
+	b, err := d.stringBody()
+	if err != nil {
 		return err
 	}
 
-	if dest != emptyValue {
-		if !canSetString(dest) {
-			return fmt.Errorf("can't decode string to %s", dest.Kind())
+	switch {
+	case dest == emptyValue:
+	case canSetString(dest):
+		if d.validateUTF8 && !utf8.Valid(b) {
+			return d.syntaxError(off, "invalid UTF-8 in string")
 		}
-		dest.SetString(string(b))
-	}
-	if buf != nil {
-		*buf = append(*buf, b...)
+		if d.zeroCopy {
+			dest.SetString(aliasString(b))
+		} else {
+			dest.SetString(string(b))
+		}
+	case canSetBytes(dest):
+		// unless asked not to, the bytes are copied as the data is reused
+		if d.zeroCopy {
+			dest.SetBytes(b[:len(b):len(b)])
+		} else {
+			dest.SetBytes(append(make([]byte, 0, len(b)), b...))
+		}
+	default:
+		return d.typeError(off, dest.Type())
 	}
 	return nil
 }
 
-func decodeInt8(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typInt8)
+func (d *decodeState) integer(m byte, dest reflect.Value, typ reflect.Type) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typ)
 	if err != nil {
 		return err
 	}
 
-	b := make([]byte, 1)
-	if _, err := r.Read(b); err != nil {
+	v, err := d.int(m)
+	if err != nil {
 		return err
 	}
 
 	if dest != emptyValue {
 		if !canSetInt(dest) {
-			return fmt.Errorf("can't decode int8 to %s", dest.Kind())
+			return d.typeError(off, dest.Type())
 		}
-		dest.SetInt(int64(b[0]))
-	}
-	if buf != nil {
-		*buf = append(*buf, b...)
+		dest.SetInt(v)
 	}
 	return nil
 }
 
-func decodeInt16(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typInt16)
+func (d *decodeState) real(dest reflect.Value) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typFloat64)
 	if err != nil {
 		return err
 	}
 
-	b := make([]byte, 2)
-	if _, err := r.Read(b); err != nil {
+	b, err := d.next(8)
+	if err != nil {
 		return err
 	}
 
 	if dest != emptyValue {
-		if !canSetInt(dest) {
-			return fmt.Errorf("can't decode int16 to %s", dest.Kind())
+		if !canSetFloat(dest) {
+			return d.typeError(off, dest.Type())
 		}
-		v := order.Uint16(b)
-		dest.SetInt(int64(v))
-	}
-	if buf != nil {
-		*buf = append(*buf, b...)
+		dest.SetFloat(math.Float64frombits(order.Uint64(b)))
 	}
+
 	return nil
 }
 
-func decodeInt32(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typInt32)
+func (d *decodeState) bool(v bool, dest reflect.Value) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typBool)
 	if err != nil {
 		return err
 	}
 
-	b := make([]byte, 4)
-	if _, err := r.Read(b); err != nil {
-		return err
-	}
-
-	if dest != emptyValue {
-		if !canSetInt(dest) {
-			return fmt.Errorf("can't decode int32 to %s", dest.Kind())
-		}
-		v := order.Uint32(b)
-		dest.SetInt(int64(v))
-	}
-	if buf != nil {
-		*buf = append(*buf, b...)
+	if !canSetBool(dest) {
+		return d.typeError(off, dest.Type())
 	}
+	dest.SetBool(v)
 	return nil
 }
 
-func decodeInt64(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typInt64)
+func (d *decodeState) template(dest reflect.Value) error {
+	off := d.off - 1
+	dest, err := d.prep(off, dest, typGenericSlice)
 	if err != nil {
 		return err
 	}
 
-	b := make([]byte, 8)
-	if _, err := r.Read(b); err != nil {
-		return err
-	}
-
-	if dest != emptyValue {
-		if !canSetInt(dest) {
-			return fmt.Errorf("can't decode int64 to %s", dest.Kind())
-		}
-		v := order.Uint64(b)
-		dest.SetInt(int64(v))
-	}
-	if buf != nil {
-		*buf = append(*buf, b...)
-	}
-	return nil
-}
-
-func decodeReal(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typFloat64)
-	if err != nil {
-		return err
+	if dest != emptyValue && dest.Kind() != reflect.Slice {
+		return d.typeError(off, dest.Type())
 	}
 
-	b := make([]byte, 8)
-	if _, err := r.Read(b); err != nil {
+	if err := d.push(off); err != nil {
 		return err
 	}
 
-	if dest != emptyValue {
-		if !canSetFloat(dest) {
-			return fmt.Errorf("can't decode real to %s", dest.Kind())
+	if dest == emptyValue {
+		// skip without allocating the field names
+		if m, err := d.peek(); err == nil && m != 0x00 {
+			return d.syntaxError(d.off, fmt.Sprintf("template field names must be an array, found %s", Type(m)))
 		}
-		v := order.Uint64(b)
-		dest.SetFloat(math.Float64frombits(v))
-	}
-	if buf != nil {
-		*buf = append(*buf, b...)
-	}
-
-	return nil
-}
 
-func decodeBool(v bool, dest reflect.Value) error {
-	dest, err := prep(dest, typBool)
-	if err != nil {
-		return err
-	}
+		start := d.off
+		if err := d.value(emptyValue); err != nil {
+			return err
+		}
 
-	if !canSetBool(dest) {
-		return fmt.Errorf("can't decode bool to %s", dest.Kind())
-	}
-	dest.SetBool(v)
-	return nil
-}
+		names := decodeState{data: d.data, off: start + 1}
+		width, err := names.length()
+		if err != nil {
+			return err
+		}
 
-func decodeTemplate(r io.Reader, dest reflect.Value, buf *[]byte) error {
-	dest, err := prep(dest, typGenericSlice)
-	if err != nil {
-		return err
-	}
+		length, err := d.rows(width)
+		if err != nil {
+			return err
+		}
 
-	if dest != emptyValue && dest.Kind() != reflect.Slice {
-		return fmt.Errorf("Expected slice, found %s", dest.Kind())
+		for i := 0; i < length*width; i++ {
+			if err := d.value(emptyValue); err != nil {
+				return err
+			}
+		}
+		d.pop()
+		return nil
 	}
 
 	var fieldNames []string
-	if err := decodeValue(r, reflect.ValueOf(&fieldNames), buf); err != nil {
+	if err := d.value(reflect.ValueOf(&fieldNames)); err != nil {
 		return err
 	}
 
-	var length int
-	if err := decodeValue(r, reflect.ValueOf(&length), buf); err != nil {
+	length, err := d.rows(len(fieldNames))
+	if err != nil {
 		return err
 	}
 
-	if dest != emptyValue {
-		dest.Set(reflect.MakeSlice(dest.Type(), length, length))
-	}
+	dest.Set(makeSlice(dest.Type(), length))
+
+	var (
+		// resolved struct fields, looked up once per template
+		sfields []field
+		keys    []reflect.Value
+	)
 
-	var sfields structFields
 	for i := 0; i < length; i++ {
-		item := emptyValue
-		if dest != emptyValue {
-			item = dest.Index(i)
-			item, err = prep(item, typGenericMap)
-			if err != nil {
-				return err
-			}
+		dest.Set(growSlice(dest, i, length))
+
+		item, err := d.prep(off, dest.Index(i), typGenericMap)
+		if err != nil {
+			return err
+		}
 
-			if item.Kind() != reflect.Map && item.Kind() != reflect.Struct {
-				return fmt.Errorf("Expected slice of struct or slice of map, found slice of %s", item.Kind())
+		if item.Kind() == reflect.Ptr {
+			if item.IsNil() {
+				item.Set(reflect.New(item.Type().Elem()))
 			}
+			item = item.Elem()
 		}
 
-		for _, field := range fieldNames {
-			switch item.Kind() {
-			case reflect.Invalid:
-				if buf == nil {
-					panic("should only get reflect.Invalid if buf is non-nil")
+		switch item.Kind() {
+		case reflect.Map:
+			if item.Type().Key().Kind() != reflect.String {
+				return d.typeError(off, dest.Type())
+			}
+			if keys == nil {
+				keys = make([]reflect.Value, len(fieldNames))
+				for j, name := range fieldNames {
+					keys[j] = reflect.ValueOf(name).Convert(item.Type().Key())
 				}
-				v := emptyValue
-				if err := decodeValue(r, v, buf); err != nil {
-					return err
+			}
+			if item.IsNil() {
+				item.Set(reflect.MakeMapWithSize(item.Type(), len(fieldNames)))
+			}
+			for j := range fieldNames {
+				if m, _ := d.peek(); m == 0x0c {
+					d.off++
+					continue
 				}
-			case reflect.Map:
+
 				v := reflect.New(item.Type().Elem())
-				if err := decodeValue(r, v, buf); err != nil {
-					return err
+				if err := d.value(v); err != nil {
+					return addPath(addPath(err, fieldNames[j]), indexPath(i))
 				}
-				item.SetMapIndex(reflect.ValueOf(field), v.Elem())
-			case reflect.Struct:
-				if sfields == nil {
-					sfields = fields(item.Type())
+				item.SetMapIndex(keys[j], v.Elem())
+			}
+		case reflect.Struct:
+			if sfields == nil {
+				tfields := fields(item.Type())
+				sfields = make([]field, len(fieldNames))
+				for j, name := range fieldNames {
+					f, ok := tfields.field([]byte(name))
+					if !ok && d.disallowUnknownFields {
+						return addPath(d.unknownFieldError(off, name, item.Type()), indexPath(i))
+					}
+					// unknown fields are left with a nil Index and skipped
+					sfields[j] = f
 				}
-
-				f, ok := sfields.field(field)
-				if !ok {
-					return fmt.Errorf("Field %s not found", field)
+			}
+			for j, f := range sfields {
+				var err error
+				if f.Index != nil {
+					err = d.field(fieldByIndex(item, f.Index).Addr(), f)
+				} else {
+					err = d.value(emptyValue)
 				}
-
-				if err := decodeValue(r, item.FieldByIndex(f.Index).Addr(), buf); err != nil {
-					return err
+				if err != nil {
+					return addPath(addPath(err, fieldNames[j]), indexPath(i))
 				}
 			}
+		default:
+			return d.typeError(off, dest.Type())
 		}
 	}
 
+	d.pop()
 	return nil
 }
 
-func prep(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
+// prep allocates a value of typ for an empty interface v. off is
+// the offset of the value being decoded, for errors
+func (d *decodeState) prep(off int, v reflect.Value, typ reflect.Type) (reflect.Value, error) {
 	if v.Kind() != reflect.Interface {
 		return v, nil
 	}
@@ -492,7 +874,7 @@ func prep(v reflect.Value, typ reflect.Type) (reflect.Value, error) {
 		return val.Elem(), nil
 	}
 
-	return reflect.Value{}, fmt.Errorf("Interface found, but expected %s", typ)
+	return reflect.Value{}, d.typeError(off, v.Type())
 }
 
 // canSetString checks if we can call SetString() on v - https://golang.org/pkg/reflect/#Value.SetString
@@ -500,6 +882,20 @@ func canSetString(v reflect.Value) bool {
 	return v.CanSet() && v.Kind() == reflect.String
 }
 
+// aliasString returns a string sharing the memory of b, which must not
+// be modified while the string is in use
+func aliasString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// canSetBytes checks if we can call SetBytes() on v - https://golang.org/pkg/reflect/#Value.SetBytes
+func canSetBytes(v reflect.Value) bool {
+	return v.CanSet() && v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+}
+
 // canSetInt checks if we can call SetInt() on v - https://golang.org/pkg/reflect/#Value.SetInt
 func canSetInt(v reflect.Value) bool {
 	validType := v.Kind() == reflect.Int || v.Kind() == reflect.Int8 || v.Kind() == reflect.Int16 || v.Kind() == reflect.Int32 || v.Kind() == reflect.Int64
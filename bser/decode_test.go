@@ -3,8 +3,10 @@ package bser
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
+	"unsafe"
 )
 
 type nonDecodable interface {
@@ -227,6 +229,28 @@ var decodeTests = map[string]decodeTest{
 			return dst, err
 		},
 	},
+	"utf8_string": {
+		encoded: []byte(
+			"\x00\x01\x03\x08\x0d\x03\x05hello",
+		),
+		expectedData: "hello",
+		doDecode: func(decoder *Decoder) (interface{}, error) {
+			var dst string
+			err := decoder.Decode(&dst)
+			return dst, err
+		},
+	},
+	"utf8_string_map": {
+		encoded: []byte(
+			"\x00\x01\x03\x0b\x01\x03\x01\x0d\x03\x01a\x0d\x03\x01b",
+		),
+		expectedData: map[string]string{"a": "b"},
+		doDecode: func(decoder *Decoder) (interface{}, error) {
+			var dst map[string]string
+			err := decoder.Decode(&dst)
+			return dst, err
+		},
+	},
 	"string_alias": {
 		encoded: []byte(
 			"\x00\x01\x03\x08\x02\x03\x05hello",
@@ -646,6 +670,68 @@ var decodeTests = map[string]decodeTest{
 			return dst, err
 		},
 	},
+	"neg_ints_to_int": {
+		encoded: []byte(
+			"\x00\x01\x03\x16\x00\x03\x04\x03\xff\x04\x17\xfc\x05_y\xfe\xff\x06\x00\xa2/M\xff\xff\xff\xff",
+		),
+		expectedData: []int{-1, -1001, -100001, -3_000_000_000},
+		doDecode: func(decoder *Decoder) (interface{}, error) {
+			var dst []int
+			err := decoder.Decode(&dst)
+			return dst, err
+		},
+	},
+	"nested_template": {
+		encoded: []byte(
+			"\x00\x01\x03\x49\x01\x03\x02\x02\x03\x04Lead\x01\x03\x02\x02\x03\x04Name\x02\x03\x04fred\x02\x03\x03Age\x03\x14" +
+				"\x02\x03\x07Members\x0b\x00\x03\x02\x02\x03\x04Name\x02\x03\x03Age\x03\x01\x02\x03\x04pete\x03\x1e",
+		),
+		expectedData: team{Lead: person{Name: "fred", Age: 20}, Members: []person{{Name: "pete", Age: 30}}},
+		doDecode: func(decoder *Decoder) (interface{}, error) {
+			var dst team
+			err := decoder.Decode(&dst)
+			return dst, err
+		},
+	},
+	"null_to_interface": {
+		encoded:      []byte("\x00\x01\x03\x01\x0a"),
+		expectedData: nil,
+		doDecode: func(decoder *Decoder) (interface{}, error) {
+			dst := interface{}("not nil")
+			err := decoder.Decode(&dst)
+			return dst, err
+		},
+	},
+	"truncated_string": {
+		encoded:   []byte("\x00\x01\x03\x05\x02\x03\x05hel"), // string header claims 5 bytes, only 3 follow
+		expectErr: true,
+		doDecode: func(decoder *Decoder) (interface{}, error) {
+			var dst string
+			err := decoder.Decode(&dst)
+			return dst, err
+		},
+	},
+	"byte_slice": {
+		// ["a\xffb", ""] decodes without assuming the bytes are UTF-8
+		encoded: []byte(
+			"\x00\x01\x03\x0c\x00\x03\x02\x02\x03\x03a\xffb\x02\x03\x00",
+		),
+		expectedData: [][]byte{[]byte("a\xffb"), {}},
+		doDecode: func(dec *Decoder) (interface{}, error) {
+			var v [][]byte
+			err := dec.Decode(&v)
+			return v, err
+		},
+	},
+	"template_ptr_slice": {
+		encoded:      mustMarshalPDU([]*person{{Name: "fred", Age: 20}, {Name: "wilma"}}),
+		expectedData: []*person{{Name: "fred", Age: 20}, {Name: "wilma"}},
+		doDecode: func(dec *Decoder) (interface{}, error) {
+			var v []*person
+			err := dec.Decode(&v)
+			return v, err
+		},
+	},
 	"raw_message_slice": {
 		// this is just an array of strings: ["ok", "there"]
 		encoded: []byte(
@@ -693,6 +779,274 @@ func testDecode(t *testing.T, testCase decodeTest) {
 	}
 }
 
+func TestDecodeErrors(t *testing.T) {
+	type file struct {
+		Name  string `bser:"name"`
+		Mtime int    `bser:"mtime"`
+	}
+
+	var (
+		files = AppendTemplateHeader(AppendString(AppendObjectHeader(nil, 1), "files"), []string{"name", "mtime"}, 2)
+		rows  = AppendString(AppendInt(AppendString(nil, "a"), 10), "b")
+	)
+
+	var decodeErrorTests = map[string]struct {
+		encoded  []byte
+		dest     interface{}
+		expected error
+		message  string
+	}{
+		"template_field_type": {
+			encoded:  append(append(files, rows...), AppendString(nil, "x")...),
+			dest:     &struct{ Files []file }{},
+			expected: &UnmarshalTypeError{Value: TypeString, Type: reflect.TypeOf(0), Offset: len(files) + len(rows), Field: "files[1].mtime"},
+			message:  fmt.Sprintf("can't decode string to int at offset %d in field files[1].mtime", len(files)+len(rows)),
+		},
+		"count_exceeds_data": {
+			encoded:  []byte("\x00\x03\x05\x03\x01"),
+			dest:     &[]int{},
+			expected: &SyntaxError{msg: "count 5 exceeds the 2 bytes left", Offset: 1, Type: TypeInt8},
+			message:  "count 5 exceeds the 2 bytes left at offset 1",
+		},
+		"template_no_field_names": {
+			encoded:  []byte("\x0b\x00\x03\x00\x03\x03\x0a\x0a\x0a"),
+			dest:     &[]person{},
+			expected: &SyntaxError{msg: "templated array has rows but no field names", Offset: 4, Type: TypeInt8},
+			message:  "templated array has rows but no field names at offset 4",
+		},
+		"template_rows_exceed_data": {
+			encoded:  []byte("\x0b\x00\x03\x02\x02\x03\x01a\x02\x03\x01b\x03\x02\x0c\x0c\x0c"),
+			dest:     &[]person{},
+			expected: &SyntaxError{msg: "2 rows of 2 fields exceed the 3 bytes left", Offset: 12, Type: TypeInt8},
+			message:  "2 rows of 2 fields exceed the 3 bytes left at offset 12",
+		},
+		"array_invalid_marker": {
+			encoded:  []byte("\x01\x03\x01\x02\x03\x01a\x00\x03\x02\x03\x01\x0e"),
+			dest:     &map[string]interface{}{},
+			expected: &SyntaxError{msg: "invalid type marker e", Offset: 12, Type: Type(0x0e), Field: "a[1]"},
+			message:  "invalid type marker e at offset 12 in field a[1]",
+		},
+		"object_key_type": {
+			encoded:  []byte("\x01\x03\x01\x03\x01\x03\x01"),
+			dest:     &map[string]int{},
+			expected: &SyntaxError{msg: "object key must be a string, found int8", Offset: 3, Type: TypeInt8},
+			message:  "object key must be a string, found int8 at offset 3",
+		},
+		"truncated_string": {
+			encoded:  []byte("\x00\x03\x01\x02\x03\x05hel"),
+			dest:     &[]string{},
+			expected: &SyntaxError{msg: "unexpected end of data", Offset: 9, Type: TypeString, Field: "[0]"},
+			message:  "unexpected end of data at offset 9 in field [0]",
+		},
+		"interface_type": {
+			encoded:  []byte("\x00\x03\x01\x03\x01"),
+			dest:     &[]nonDecodable{},
+			expected: &UnmarshalTypeError{Value: TypeInt8, Type: reflect.TypeOf((*nonDecodable)(nil)).Elem(), Offset: 3, Field: "[0]"},
+			message:  "can't decode int8 to bser.nonDecodable at offset 3 in field [0]",
+		},
+	}
+
+	for testName, testCase := range decodeErrorTests {
+		t.Run(testName, func(t *testing.T) {
+			err := UnmarshalValue(testCase.encoded, testCase.dest)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Fatalf("unexpected error:\n\nexpected = %#v\n\nactual = %#v", testCase.expected, err)
+			}
+			if err.Error() != testCase.message {
+				t.Fatalf("unexpected error message:\n\nexpected = %s\n\nactual = %s", testCase.message, err)
+			}
+		})
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	type extraPerson struct {
+		Name  string
+		Extra []int
+	}
+
+	var (
+		extra  = extraPerson{Name: "fred", Extra: []int{1, 2}}
+		nested = [][][]int{{{1}}}
+	)
+
+	var decoderOptionTests = map[string]struct {
+		value     interface{}
+		setup     func(d *Decoder)
+		dest      interface{}
+		expected  interface{}
+		expectErr bool
+	}{
+		"unknown_field_skipped": {
+			value:    extra,
+			setup:    func(d *Decoder) {},
+			dest:     &person{},
+			expected: person{Name: "fred"},
+		},
+		"unknown_field_disallowed": {
+			value:     extra,
+			setup:     func(d *Decoder) { d.DisallowUnknownFields() },
+			dest:      &person{},
+			expectErr: true,
+		},
+		"unknown_template_field_skipped": {
+			value:    []extraPerson{extra, extra},
+			setup:    func(d *Decoder) {},
+			dest:     &[]person{},
+			expected: []person{{Name: "fred"}, {Name: "fred"}},
+		},
+		"unknown_template_field_disallowed": {
+			value:     []extraPerson{extra},
+			setup:     func(d *Decoder) { d.DisallowUnknownFields() },
+			dest:      &[]person{},
+			expectErr: true,
+		},
+		"max_pdu_size": {
+			value:     "hello",
+			setup:     func(d *Decoder) { d.SetMaxPDUSize(6) },
+			dest:      new(string),
+			expectErr: true,
+		},
+		"max_pdu_size_fits": {
+			value:    "hello",
+			setup:    func(d *Decoder) { d.SetMaxPDUSize(8) },
+			dest:     new(string),
+			expected: "hello",
+		},
+		"max_depth": {
+			value:     nested,
+			setup:     func(d *Decoder) { d.SetMaxDepth(2) },
+			dest:      &[][][]int{},
+			expectErr: true,
+		},
+		"max_depth_fits": {
+			value:    nested,
+			setup:    func(d *Decoder) { d.SetMaxDepth(3) },
+			dest:     &[][][]int{},
+			expected: [][][]int{{{1}}},
+		},
+		"max_elements": {
+			value:     []int{1, 2, 3},
+			setup:     func(d *Decoder) { d.SetMaxElements(2) },
+			dest:      &[]int{},
+			expectErr: true,
+		},
+		"invalid_utf8_allowed": {
+			value:    "a\xffb",
+			setup:    func(d *Decoder) {},
+			dest:     new(string),
+			expected: "a\xffb",
+		},
+		"invalid_utf8_validated": {
+			value:     "a\xffb",
+			setup:     func(d *Decoder) { d.ValidateUTF8() },
+			dest:      new(string),
+			expectErr: true,
+		},
+		"invalid_utf8_bytes_validated": {
+			value:    "a\xffb",
+			setup:    func(d *Decoder) { d.ValidateUTF8() },
+			dest:     new([]byte),
+			expected: []byte("a\xffb"),
+		},
+		"max_elements_template": {
+			value:     []person{{}, {}, {}},
+			setup:     func(d *Decoder) { d.SetMaxElements(2) },
+			dest:      &[]person{},
+			expectErr: true,
+		},
+	}
+
+	t.Run("oversized_header", func(t *testing.T) {
+		// header claims a 1GB PDU but only a few bytes follow
+		decoder := NewDecoder(bytes.NewReader([]byte("\x00\x01\x05\x00\x00\x00\x40\x02\x03\x01a")))
+
+		var dst string
+		if err := decoder.Decode(&dst); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected %s, found %v", io.ErrUnexpectedEOF, err)
+		}
+	})
+
+	for testName, testCase := range decoderOptionTests {
+		t.Run(testName, func(t *testing.T) {
+			decoder := NewDecoder(bytes.NewReader(mustMarshalPDU(testCase.value)))
+			testCase.setup(decoder)
+
+			err := decoder.Decode(testCase.dest)
+			if testCase.expectErr {
+				if err == nil {
+					t.Fatal("unexpectedly no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if dst := reflect.ValueOf(testCase.dest).Elem().Interface(); !reflect.DeepEqual(dst, testCase.expected) {
+				t.Fatalf("unexpected decoded dst:\n\nexpected = %#v\n\nactual = %#v", testCase.expected, dst)
+			}
+		})
+	}
+}
+
+func TestUnknownFieldError(t *testing.T) {
+	type extraPerson struct {
+		Name  string
+		Extra []int
+	}
+
+	type family struct {
+		Parent person `bser:"parent"`
+	}
+
+	var (
+		extra      = extraPerson{Name: "fred", Extra: []int{1, 2}}
+		typePerson = reflect.TypeOf(person{})
+	)
+
+	var unknownFieldTests = map[string]struct {
+		value    interface{}
+		dest     interface{}
+		expected *UnknownFieldError
+		message  string
+	}{
+		"object": {
+			value:    extra,
+			dest:     &person{},
+			expected: &UnknownFieldError{Name: "Extra", Type: typePerson, Offset: 25},
+			message:  `unknown field "Extra" in bser.person at offset 25`,
+		},
+		"nested": {
+			value:    map[string]interface{}{"parent": extra},
+			dest:     &family{},
+			expected: &UnknownFieldError{Name: "Extra", Type: typePerson, Offset: 37, Field: "parent"},
+			message:  `unknown field "Extra" in bser.person at offset 37 in field parent`,
+		},
+		"template": {
+			value:    []extraPerson{extra, extra},
+			dest:     &[]person{},
+			expected: &UnknownFieldError{Name: "Extra", Type: typePerson, Offset: 0, Field: "[0]"},
+			message:  `unknown field "Extra" in bser.person at offset 0 in field [0]`,
+		},
+	}
+
+	for testName, testCase := range unknownFieldTests {
+		t.Run(testName, func(t *testing.T) {
+			decoder := NewDecoder(bytes.NewReader(mustMarshalPDU(testCase.value)))
+			decoder.DisallowUnknownFields()
+
+			err := decoder.Decode(testCase.dest)
+			if !reflect.DeepEqual(err, testCase.expected) {
+				t.Fatalf("unexpected error:\n\nexpected = %#v\n\nactual = %#v", testCase.expected, err)
+			}
+			if err.Error() != testCase.message {
+				t.Fatalf("unexpected error message:\n\nexpected = %s\n\nactual = %s", testCase.message, err)
+			}
+		})
+	}
+}
+
 type decodeBench struct {
 	encoded  []byte
 	doDecode func(decoder *Decoder) (interface{}, error)
@@ -816,3 +1170,320 @@ func BenchmarkDecode(b *testing.B) {
 		benchDecErr = err
 	}
 }
+
+// benchFile mirrors the file objects returned by find and subscription payloads
+type benchFile struct {
+	Name   string `bser:"name"`
+	Size   int    `bser:"size"`
+	Mode   int    `bser:"mode"`
+	Mtime  int    `bser:"mtime"`
+	Exists bool   `bser:"exists"`
+	New    bool   `bser:"new"`
+	Oclock string `bser:"oclock"`
+}
+
+type benchFind struct {
+	Version string      `bser:"version"`
+	Clock   string      `bser:"clock"`
+	Files   []benchFile `bser:"files"`
+}
+
+type benchSubscribe struct {
+	Version         string      `bser:"version"`
+	Clock           string      `bser:"clock"`
+	Files           []benchFile `bser:"files"`
+	IsFreshInstance bool        `bser:"is_fresh_instance"`
+	Root            string      `bser:"root"`
+	Subscription    string      `bser:"subscription"`
+	Unilateral      bool        `bser:"unilateral"`
+}
+
+// filesTemplate builds a templated array of numFiles files the way the watchman server does
+func filesTemplate(numFiles int) RawMessage {
+	names := []string{"name", "size", "mode", "mtime", "exists", "new", "oclock"}
+
+	b := []byte{0x0b}
+	b = append(b, mustMarshalValue(names)...)
+	b = append(b, mustMarshalValue(numFiles)...)
+	for i := 0; i < numFiles; i++ {
+		row := []interface{}{
+			fmt.Sprintf("src/github.com/jonasi/watchman/pkg%d/file_%d.go", i%50, i),
+			4096 + i,
+			0100644,
+			1577836800 + i,
+			true,
+			i%10 == 0,
+			fmt.Sprintf("c:1577836800:1234:1:%d", i),
+		}
+		for _, v := range row {
+			b = append(b, mustMarshalValue(v)...)
+		}
+	}
+
+	return b
+}
+
+func findPayload(numFiles int) []byte {
+	return mustMarshalPDU(map[string]interface{}{
+		"version": "4.9.0",
+		"clock":   "c:1577836800:1234:1:42",
+		"files":   filesTemplate(numFiles),
+	})
+}
+
+func subscribePayload(numFiles int) []byte {
+	return mustMarshalPDU(map[string]interface{}{
+		"version":           "4.9.0",
+		"clock":             "c:1577836800:1234:1:42",
+		"files":             filesTemplate(numFiles),
+		"is_fresh_instance": false,
+		"root":              "/home/user/src/github.com/jonasi/watchman",
+		"subscription":      "sub1",
+		"unilateral":        true,
+	})
+}
+
+func mustMarshalValue(v interface{}) []byte {
+	b, err := MarshalValue(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func mustMarshalPDU(v interface{}) []byte {
+	b, err := MarshalPDU(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+var payloadSizes = []int{10, 100, 1000}
+
+func BenchmarkDecodeFind(b *testing.B) {
+	for _, numFiles := range payloadSizes {
+		pdu := findPayload(numFiles)
+		b.Run(fmt.Sprintf("num_files=%d", numFiles), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(pdu)))
+			for i := 0; i < b.N; i++ {
+				var dst benchFind
+				benchDecErr = NewDecoder(bytes.NewReader(pdu)).Decode(&dst)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeSubscribe(b *testing.B) {
+	for _, numFiles := range payloadSizes {
+		pdu := subscribePayload(numFiles)
+		b.Run(fmt.Sprintf("num_files=%d", numFiles), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(pdu)))
+			for i := 0; i < b.N; i++ {
+				// mirror the client: decode to a raw message first, then into the event
+				var (
+					raw RawMessage
+					dst benchSubscribe
+				)
+				if benchDecErr = NewDecoder(bytes.NewReader(pdu)).Decode(&raw); benchDecErr != nil {
+					continue
+				}
+				benchDecErr = UnmarshalValue(raw, &dst)
+			}
+		})
+	}
+}
+
+func BenchmarkDecoderStream(b *testing.B) {
+	benchmarkDecoderStream(b, false)
+}
+
+func BenchmarkDecoderStreamZeroCopy(b *testing.B) {
+	benchmarkDecoderStream(b, true)
+}
+
+func benchmarkDecoderStream(b *testing.B, zeroCopy bool) {
+	pdu := subscribePayload(100)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(pdu)))
+
+	var stream bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		stream.Write(pdu)
+	}
+
+	dec := NewDecoder(&stream)
+	if zeroCopy {
+		dec.ZeroCopy()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dst benchSubscribe
+		benchDecErr = dec.Decode(&dst)
+	}
+}
+
+func TestDecoderZeroCopy(t *testing.T) {
+	type event struct {
+		Name  string     `bser:"name"`
+		Bytes []byte     `bser:"bytes"`
+		Raw   RawMessage `bser:"raw"`
+	}
+
+	var (
+		in     = event{Name: "fred", Bytes: []byte("ab"), Raw: RawMessage(AppendInt(nil, 1))}
+		pdu    = mustMarshalPDU(in)
+		stream bytes.Buffer
+	)
+	stream.Write(pdu)
+	stream.Write(pdu)
+
+	dec := NewDecoder(&stream)
+	dec.ZeroCopy()
+
+	var out event
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected decoded value:\n\nexpected = %#v\n\nactual = %#v", in, out)
+	}
+
+	within := func(b []byte) bool {
+		start := &dec.buf[0]
+		end := &dec.buf[len(dec.buf)-1]
+		p := &b[0]
+		return uintptr(unsafe.Pointer(p)) >= uintptr(unsafe.Pointer(start)) && uintptr(unsafe.Pointer(p)) <= uintptr(unsafe.Pointer(end))
+	}
+	if !within([]byte(out.Raw)) || !within(out.Bytes) {
+		t.Fatal("expected values to alias the decoder buffer")
+	}
+	if cap(out.Bytes) != len(out.Bytes) {
+		t.Fatalf("expected capacity to be limited to %d, found %d", len(out.Bytes), cap(out.Bytes))
+	}
+
+	strs := mustMarshalPDU([]string{"dir/a", "dir/b", "dir/c", "dir/d", "dir/e", "dir/f", "dir/g", "dir/h"})
+	allocs := func(zeroCopy bool) float64 {
+		return testing.AllocsPerRun(100, func() {
+			dec := NewDecoder(bytes.NewReader(strs))
+			if zeroCopy {
+				dec.ZeroCopy()
+			}
+			var dst []string
+			if err := dec.Decode(&dst); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+	if copied, aliased := allocs(false), allocs(true); aliased > copied-8 {
+		t.Fatalf("expected at least 8 fewer allocations, found %v copying and %v aliasing", copied, aliased)
+	}
+}
+
+// keepBSER keeps the data passed to UnmarshalBSER without copying it
+type keepBSER struct {
+	data []byte
+}
+
+func (k *keepBSER) UnmarshalBSER(b []byte) error {
+	k.data = b
+	return nil
+}
+
+func TestDecoderUnmarshalerOwnsData(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(mustMarshalPDU("aaaa"))
+	stream.Write(mustMarshalPDU("bbbb"))
+
+	var (
+		dec         = NewDecoder(&stream)
+		first, next keepBSER
+	)
+
+	if err := dec.Decode(&first); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := dec.Decode(&next); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if expected := AppendString(nil, "aaaa"); !bytes.Equal(expected, first.data) {
+		t.Fatalf("unexpected retained data:\n\nexpected = %v\n\nactual = %v", expected, first.data)
+	}
+	if expected := AppendString(nil, "bbbb"); !bytes.Equal(expected, next.data) {
+		t.Fatalf("unexpected data:\n\nexpected = %v\n\nactual = %v", expected, next.data)
+	}
+}
+
+func TestDecodeRawMessageCopiesOnce(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(mustMarshalPDU("aaaa"))
+	stream.Write(mustMarshalPDU("bbbb"))
+
+	var (
+		dec         = NewDecoder(&stream)
+		first, next RawMessage
+	)
+
+	if err := dec.Decode(&first); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := dec.Decode(&next); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := AppendString(nil, "aaaa"); !bytes.Equal(expected, first) {
+		t.Fatalf("unexpected retained data:\n\nexpected = %v\n\nactual = %v", expected, first)
+	}
+
+	// RawMessage copies into its own capacity, so decoding into
+	// one that is large enough allocates nothing for the data
+	var (
+		b   = AppendString(nil, "cccc")
+		raw = make(RawMessage, 0, 64)
+	)
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := UnmarshalValue(b, &raw); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, found %v", allocs)
+	}
+}
+
+func TestDecodeGrowsSlices(t *testing.T) {
+	// only a few of these fit in the bytes allocated up front
+	type big struct {
+		Data [1 << 18]byte
+	}
+
+	const n = 10
+
+	b := AppendArrayHeader(nil, n)
+	for i := 0; i < n; i++ {
+		b = AppendNull(b)
+	}
+
+	var dest []big
+	if err := UnmarshalValue(b, &dest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dest) != n {
+		t.Fatalf("expected %d values, found %d", n, len(dest))
+	}
+
+	rows := AppendTemplateHeader(nil, []string{"Data"}, n)
+	for i := 0; i < n; i++ {
+		rows = append(rows, byte(TypeMissing))
+	}
+
+	dest = nil
+	if err := UnmarshalValue(rows, &dest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dest) != n {
+		t.Fatalf("expected %d rows, found %d", n, len(dest))
+	}
+}
@@ -0,0 +1,37 @@
+// Package bser implements the BSER binary serialization used by watchman.
+//
+// # Struct fields
+//
+// Exported struct fields are encoded and decoded as object keys. A field
+// is named by its bser tag, or by its Go name if it has none, and a tag
+// of "-" leaves it out. Decoding matches keys to names exactly, and then
+// without regard to case. The options after the name in a tag are:
+//
+//	omitempty   leave out an empty value, or encode it as missing in a
+//	            templated array
+//	s, ms, us, ns
+//	            the unit of a time.Time or time.Duration field
+//
+// The fields of an embedded struct are encoded as if they were fields of
+// the outer struct. When two fields have the same name, the later one is
+// encoded and decoded, and the earlier one is ignored. Embedded fields are
+// later than the fields of the outer struct.
+//
+// Compatibility: releases before these rules were introduced ignored
+// bser tags when encoding, so every exported field was encoded under its
+// Go name, including fields tagged "-". They also encoded an exported
+// embedded struct as an object nested under its type name. Decoding
+// already followed these rules, so a value now decodes into the fields it
+// was encoded from. A struct with tags or embedded structs now encodes to
+// different bytes. A caller that depends on the old encoding should
+// remove the tags, or make the embedded struct a named field.
+//
+// # Marshaler and Unmarshaler implementations
+//
+// A type that implements Marshaler or Unmarshaler encodes or decodes
+// itself in place of the reflection based codec, as the code generated
+// by cmd/bsergen does. The Append functions write values to build the
+// encoding, Cursor reads them back one at a time, and FieldSet matches
+// object keys to fields. They follow the same rules as the reflection
+// based codec, so the two can be mixed freely.
+package bser
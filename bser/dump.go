@@ -0,0 +1,248 @@
+package bser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// dumpBytes is the number of bytes shown on each line of a Dump
+const dumpBytes = 8
+
+// Dump writes an annotated listing of the PDU pdu to w, one line per
+// header, marker and value, giving the offset of each, its bytes, its
+// type and, for lengths and counts, the width of the integer encoding
+// them. Nested values are indented beneath their parents. If pdu is
+// malformed, everything up to the problem is written before the error
+// is returned. That includes a PDU cut short, whose header and
+// whatever values are complete are written.
+func Dump(w io.Writer, pdu []byte) error {
+	r := NewPDUReader(bytes.NewReader(pdu))
+	body, readErr := r.ReadPDU(nil)
+
+	h := r.Header()
+	if h.Raw == nil {
+		// the header itself is short or invalid
+		n := len(pdu)
+		if n > maxPDUHeader {
+			n = maxPDUHeader
+		}
+
+		p := dumper{w: w}
+		p.line(pdu[:n], 0, 0, "error: "+readErr.Error())
+		return readErr
+	}
+
+	if readErr != nil {
+		body = pdu[len(h.Raw):]
+	}
+
+	p := dumper{w: w, d: decodeState{data: body}, base: len(h.Raw)}
+
+	desc := fmt.Sprintf("PDU v1, length %d (%s)", h.Length, Type(h.Raw[2]))
+	if h.Version == 2 {
+		desc = fmt.Sprintf("PDU v2, capabilities %#x, length %d (%s)", h.Capabilities, h.Length, Type(h.Raw[6]))
+	}
+	p.line(h.Raw, -len(h.Raw), 0, desc)
+
+	if err := p.value(0, ""); err != nil {
+		return err
+	}
+
+	if readErr != nil {
+		return p.fail(0, readErr)
+	}
+
+	if p.d.off != len(body) {
+		return p.fail(0, p.d.syntaxError(p.d.off, "unexpected data after value"))
+	}
+
+	return p.err
+}
+
+// dumper writes the lines of a Dump
+type dumper struct {
+	w    io.Writer
+	d    decodeState
+	base int // offset of the value within the PDU
+	err  error
+}
+
+// line writes b, found at offset off of the value, with desc indented
+// by depth
+func (p *dumper) line(b []byte, off, depth int, desc string) {
+	if p.err != nil {
+		return
+	}
+
+	var hex strings.Builder
+	for i, c := range b {
+		if i == dumpBytes-1 && len(b) > dumpBytes {
+			hex.WriteString(" ..")
+			break
+		}
+		if i > 0 {
+			hex.WriteByte(' ')
+		}
+		fmt.Fprintf(&hex, "%02x", c)
+	}
+
+	_, p.err = fmt.Fprintf(p.w, "%06x  %-23s  %s%s\n", p.base+off, hex.String(), strings.Repeat("  ", depth), desc)
+}
+
+// fail writes err as a line and returns it
+func (p *dumper) fail(depth int, err error) error {
+	p.line(nil, p.d.off, depth, "error: "+err.Error())
+	return err
+}
+
+// span returns the bytes from start to the current offset
+func (p *dumper) span(start int) []byte {
+	return p.d.data[start:p.d.off]
+}
+
+// count reads a length or count and describes its width
+func (p *dumper) count() (int, string, error) {
+	m, err := p.d.peek()
+	if err != nil {
+		return 0, "", p.d.syntaxError(p.d.off, "unexpected end of data")
+	}
+
+	n, err := p.d.length()
+	return n, Type(m).String(), err
+}
+
+func (p *dumper) value(depth int, label string) error {
+	start := p.d.off
+	m, err := p.d.marker()
+	if err != nil {
+		return p.fail(depth, err)
+	}
+	p.d.m = m
+
+	if m == 0x00 || m == 0x01 || m == 0x0b {
+		if err := p.d.push(start); err != nil {
+			return p.fail(depth, err)
+		}
+		defer p.d.pop()
+	}
+
+	switch m {
+	case 0x00:
+		n, width, err := p.count()
+		if err != nil {
+			return p.fail(depth, err)
+		}
+
+		p.line(p.span(start), start, depth, fmt.Sprintf("%sarray, %d items (%s)", label, n, width))
+		for i := 0; i < n; i++ {
+			if err := p.value(depth+1, "["+strconv.Itoa(i)+"] "); err != nil {
+				return err
+			}
+		}
+	case 0x01:
+		n, width, err := p.count()
+		if err != nil {
+			return p.fail(depth, err)
+		}
+
+		p.line(p.span(start), start, depth, fmt.Sprintf("%sobject, %d fields (%s)", label, n, width))
+		for i := 0; i < n; i++ {
+			keyStart := p.d.off
+			key, err := p.d.key()
+			if err != nil {
+				return p.fail(depth+1, err)
+			}
+
+			p.line(p.span(keyStart), keyStart, depth+1, fmt.Sprintf("key %q (%s length)", key, Type(p.d.data[keyStart+1])))
+			if err := p.value(depth+2, ""); err != nil {
+				return err
+			}
+		}
+	case 0x02, 0x0d:
+		b, err := p.d.stringBody()
+		if err != nil {
+			return p.fail(depth, err)
+		}
+
+		p.line(p.span(start), start, depth, fmt.Sprintf("%s%s %q (%s length)", label, Type(m), b, Type(p.d.data[start+1])))
+	case 0x03, 0x04, 0x05, 0x06:
+		v, err := p.d.int(m)
+		if err != nil {
+			return p.fail(depth, err)
+		}
+
+		p.line(p.span(start), start, depth, fmt.Sprintf("%s%s %d", label, Type(m), v))
+	case 0x07:
+		b, err := p.d.next(8)
+		if err != nil {
+			return p.fail(depth, err)
+		}
+
+		p.line(p.span(start), start, depth, fmt.Sprintf("%sreal %v", label, math.Float64frombits(order.Uint64(b))))
+	case 0x08:
+		p.line(p.span(start), start, depth, label+"true")
+	case 0x09:
+		p.line(p.span(start), start, depth, label+"false")
+	case 0x0a:
+		p.line(p.span(start), start, depth, label+"null")
+	case 0x0b:
+		return p.template(start, depth, label)
+	case 0x0c:
+		p.line(p.span(start), start, depth, label+"missing")
+	default:
+		return p.fail(depth, p.d.syntaxError(start, fmt.Sprintf("invalid type marker %x", m)))
+	}
+
+	return p.err
+}
+
+func (p *dumper) template(start, depth int, label string) error {
+	p.line(p.span(start), start, depth, label+"template")
+
+	namesStart := p.d.off
+	if m, err := p.d.marker(); err != nil {
+		return p.fail(depth+1, err)
+	} else if m != 0x00 {
+		return p.fail(depth+1, p.d.syntaxError(namesStart, fmt.Sprintf("template field names must be an array, found %s", Type(m))))
+	}
+
+	width, widthType, err := p.count()
+	if err != nil {
+		return p.fail(depth+1, err)
+	}
+
+	p.line(p.span(namesStart), namesStart, depth+1, fmt.Sprintf("field names, %d items (%s)", width, widthType))
+	names := make([]string, width)
+	for i := range names {
+		nameStart := p.d.off
+		b, err := p.d.stringBytes()
+		if err != nil {
+			return p.fail(depth+2, err)
+		}
+
+		names[i] = string(b)
+		p.line(p.span(nameStart), nameStart, depth+2, fmt.Sprintf("[%d] %q (%s length)", i, b, Type(p.d.data[nameStart+1])))
+	}
+
+	rowsStart := p.d.off
+	rows, rowsType, err := p.count()
+	if err != nil {
+		return p.fail(depth+1, err)
+	}
+
+	p.line(p.span(rowsStart), rowsStart, depth+1, fmt.Sprintf("rows, %d (%s)", rows, rowsType))
+	for i := 0; i < rows; i++ {
+		p.line(nil, p.d.off, depth+1, fmt.Sprintf("row %d", i))
+		for _, name := range names {
+			if err := p.value(depth+2, name+": "); err != nil {
+				return err
+			}
+		}
+	}
+
+	return p.err
+}
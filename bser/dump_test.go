@@ -0,0 +1,116 @@
+package bser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	type file struct {
+		Name string `bser:"name"`
+		Size int    `bser:"size"`
+	}
+
+	var dumpTests = map[string]struct {
+		pdu       []byte
+		expected  string
+		expectErr bool
+	}{
+		"object": {
+			pdu: mustMarshalPDU(struct {
+				Files   []file  `bser:"files"`
+				Version string  `bser:"version"`
+				Ratio   float64 `bser:"ratio"`
+				Fresh   bool    `bser:"fresh"`
+			}{
+				Files:   []file{{"a", 1}, {"b", 300}},
+				Version: "4.9",
+				Ratio:   0.5,
+			}),
+			expected: `000000  00 01 03 56              PDU v1, length 86 (int8)
+000004  01 03 04                 object, 4 fields (int8)
+000007  02 03 05 66 69 6c 65 73    key "files" (int8 length)
+00000f  0b                           template
+000010  00 03 02                       field names, 2 items (int8)
+000013  02 03 04 6e 61 6d 65             [0] "name" (int8 length)
+00001a  02 03 04 73 69 7a 65             [1] "size" (int8 length)
+000021  03 02                          rows, 2 (int8)
+000023                                 row 0
+000023  02 03 01 61                      name: string "a" (int8 length)
+000027  03 01                            size: int8 1
+000029                                 row 1
+000029  02 03 01 62                      name: string "b" (int8 length)
+00002d  04 2c 01                         size: int16 300
+000030  02 03 07 76 65 72 73 ..    key "version" (int8 length)
+00003a  02 03 03 34 2e 39            string "4.9" (int8 length)
+000040  02 03 05 72 61 74 69 6f    key "ratio" (int8 length)
+000048  07 00 00 00 00 00 00 ..      real 0.5
+000051  02 03 05 66 72 65 73 68    key "fresh" (int8 length)
+000059  09                           false
+`,
+		},
+		"v2": {
+			pdu: []byte("\x00\x02\x01\x00\x00\x00\x03\x07\x00\x03\x02\x03\x01\x0c\x0a"),
+			expected: `000000  00 02 01 00 00 00 03 07  PDU v2, capabilities 0x1, length 7 (int8)
+000008  00 03 02                 array, 2 items (int8)
+00000b  03 01                      [0] int8 1
+00000d  0c                         [1] missing
+00000e                           error: unexpected data after value at offset 6
+`,
+			expectErr: true,
+		},
+		"utf8_string": {
+			pdu: []byte("\x00\x01\x03\x04\x0d\x03\x01a"),
+			expected: `000000  00 01 03 04              PDU v1, length 4 (int8)
+000004  0d 03 01 61              utf8string "a" (int8 length)
+`,
+		},
+		"invalid_marker": {
+			pdu: []byte("\x00\x01\x03\x04\x00\x03\x01\x0e"),
+			expected: `000000  00 01 03 04              PDU v1, length 4 (int8)
+000004  00 03 01                 array, 1 items (int8)
+000008                             error: invalid type marker e at offset 3
+`,
+			expectErr: true,
+		},
+		"truncated": {
+			pdu: []byte("\x00\x01\x03\x0a\x00\x03\x03\x03\x01\x03\x02"),
+			expected: `000000  00 01 03 0a              PDU v1, length 10 (int8)
+000004  00 03 03                 array, 3 items (int8)
+000007  03 01                      [0] int8 1
+000009  03 02                      [1] int8 2
+00000b                             error: unexpected end of data at offset 7
+`,
+			expectErr: true,
+		},
+		"truncated_header": {
+			pdu: []byte("\x00\x02\x01\x00"),
+			expected: `000000  00 02 01 00              error: unexpected EOF
+`,
+			expectErr: true,
+		},
+		"invalid_header": {
+			pdu: []byte("\x01\x00\x03\x01\x03"),
+			expected: `000000  01 00 03 01 03           error: Expected 0001 or 0002, found 0100
+`,
+			expectErr: true,
+		},
+	}
+
+	for testName, testCase := range dumpTests {
+		t.Run(testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Dump(&buf, testCase.pdu)
+			if testCase.expectErr && err == nil {
+				t.Fatal("unexpectedly no error")
+			}
+			if !testCase.expectErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if buf.String() != testCase.expected {
+				t.Fatalf("unexpected dump:\n\nexpected =\n%s\nactual =\n%s", testCase.expected, buf.String())
+			}
+		})
+	}
+}
@@ -2,10 +2,14 @@ package bser
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"sort"
+	"sync"
+	"time"
 )
 
 // MarshalPDU returns the BSER encoding of d
@@ -18,191 +22,393 @@ func MarshalPDU(d interface{}) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-// MarshalValue returns the BSER encoding of d
+// MarshalValue returns the BSER encoding of d. Map keys are sorted, so
+// the same value always has the same encoding.
 func MarshalValue(d interface{}) ([]byte, error) {
-	return encode(nil, d)
+	return encode(nil, d, encodeOptions{})
 }
 
-// NewEncoder returns an initialized Encoder
+// TemplateMode controls when slices are encoded as templated arrays
+type TemplateMode int
+
+const (
+	// TemplateAuto templates slices of structs, and slices of two or
+	// more maps with string keys that all share the same keys
+	TemplateAuto TemplateMode = iota
+	// TemplateAlways also templates slices of maps whose keys differ,
+	// encoding the keys a map lacks as missing values
+	TemplateAlways
+	// TemplateNever encodes every slice as a plain array
+	TemplateNever
+)
+
+// NewEncoder returns an initialized Encoder writing version 1 PDUs
 func NewEncoder(w io.Writer) *Encoder {
+	return NewPDUEncoder(NewPDUWriter(w))
+}
+
+// NewPDUEncoder returns an Encoder that writes PDUs with w
+func NewPDUEncoder(w *PDUWriter) *Encoder {
 	return &Encoder{w: w}
 }
 
 // Encoder writes and encodes BSER values to an output stream
 type Encoder struct {
-	w io.Writer
+	w    *PDUWriter
+	buf  []byte
+	opts encodeOptions
+}
+
+// SetTemplateMode sets when slices are encoded as templated arrays.
+// The default is TemplateAuto. Slices holding nil pointers or nil maps
+// are never templated.
+func (e *Encoder) SetTemplateMode(m TemplateMode) {
+	e.opts.templates = m
+}
+
+// SetSortMapKeys sets whether map keys are written in sorted order.
+// Keys are sorted by default, so encoding the same value always writes
+// the same bytes. Writing them in Go's random map order instead saves
+// sorting the keys of every map.
+func (e *Encoder) SetSortMapKeys(sorted bool) {
+	e.opts.unsortedKeys = !sorted
 }
 
 // Encode writes the value d to the output
 func (e *Encoder) Encode(d interface{}) error {
-	pdu, err := encode(nil, d)
+	// reserve room for the largest possible header in front of
+	// the value so the PDU can be written with a single call
+	if cap(e.buf) < maxPDUHeader {
+		e.buf = make([]byte, 0, 512)
+	}
+
+	buf, err := encode(e.buf[:maxPDUHeader], d, e.opts)
 	if err != nil {
 		return err
 	}
+	e.buf = buf
+
+	return e.w.writeReserved(buf)
+}
+
+func encode(buf []byte, d interface{}, opts encodeOptions) ([]byte, error) {
+	if d == nil {
+		return append(buf, 0x0a), nil
+	}
+
+	e := encodeState{buf: buf, encodeOptions: opts}
+	err := e.reflectValue(reflect.ValueOf(d))
+	return e.buf, err
+}
+
+// encodeOptions are the modes set on an Encoder
+type encodeOptions struct {
+	templates    TemplateMode
+	unsortedKeys bool
+}
+
+// encodeState accumulates the output of a single encode
+type encodeState struct {
+	buf []byte
+	encodeOptions
+}
+
+func (e *encodeState) reflectValue(v reflect.Value) error {
+	return typeEncoder(v.Type())(e, v)
+}
+
+type encoderFunc func(e *encodeState, v reflect.Value) error
+
+var encoderCache sync.Map // map[reflect.Type]encoderFunc
+
+// typeEncoder returns the cached encoderFunc for t
+func typeEncoder(t reflect.Type) encoderFunc {
+	if fi, ok := encoderCache.Load(t); ok {
+		return fi.(encoderFunc)
+	}
+
+	// to deal with recursive types, populate the cache with an
+	// indirect func before we build it. this func waits on the
+	// real func (f) to be ready and then calls it
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := encoderCache.LoadOrStore(t, encoderFunc(func(e *encodeState, v reflect.Value) error {
+		wg.Wait()
+		return f(e, v)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
+	}
+
+	f = newTypeEncoder(t)
+	wg.Done()
+	encoderCache.Store(t, f)
+	return f
+}
+
+func newTypeEncoder(t reflect.Type) encoderFunc {
+	if t.Implements(typMarshaler) {
+		return marshalerEncoder
+	}
+
+	// before TextMarshaler, which *time.Time implements
+	if isTime(t) {
+		return unitEncoder(t, 0)
+	}
+
+	if t.Implements(typTextMarshaler) {
+		return textMarshalerEncoder
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return stringEncoder
+	case reflect.Int8:
+		return intEncoder(0x03)
+	case reflect.Int16:
+		return intEncoder(0x04)
+	case reflect.Int32:
+		return intEncoder(0x05)
+	case reflect.Int64:
+		return intEncoder(0x06)
+	case reflect.Int:
+		return fitIntEncoder
+	case reflect.Bool:
+		return boolEncoder
+	case reflect.Float32, reflect.Float64:
+		return realEncoder
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return bytesEncoder
+		}
+		return newArrayEncoder(t)
+	case reflect.Array:
+		return newArrayEncoder(t)
+	case reflect.Struct:
+		return newStructEncoder(t)
+	case reflect.Map:
+		return newMapEncoder(t)
+	case reflect.Ptr, reflect.Interface:
+		return indirectEncoder
+	default:
+		return unsupportedTypeEncoder
+	}
+}
+
+func marshalerEncoder(e *encodeState, v reflect.Value) error {
+	if isNillable(v.Kind()) && v.IsNil() {
+		e.buf = append(e.buf, 0x0a)
+		return nil
+	}
 
-	header := []byte("\x00\x01")
-	header, err = encode(header, len(pdu))
+	b, err := v.Interface().(Marshaler).MarshalBSER()
 	if err != nil {
 		return err
 	}
 
-	if _, err = e.w.Write(header); err != nil {
+	e.buf = append(e.buf, b...)
+	return nil
+}
+
+func textMarshalerEncoder(e *encodeState, v reflect.Value) error {
+	if isNillable(v.Kind()) && v.IsNil() {
+		e.buf = append(e.buf, 0x0a)
+		return nil
+	}
+
+	b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
 		return err
 	}
-	_, err = e.w.Write(pdu)
-	return err
+
+	e.buf = appendBytes(e.buf, b)
+	return nil
 }
 
-func appendItem(buf []byte, m byte, data []byte) []byte {
-	buf = append(buf, m)
-	if len(data) > 0 {
-		buf = append(buf, data...)
+func stringEncoder(e *encodeState, v reflect.Value) error {
+	e.buf = appendString(e.buf, v.String())
+	return nil
+}
+
+// bytesEncoder encodes a []byte as a string of the raw bytes
+func bytesEncoder(e *encodeState, v reflect.Value) error {
+	if v.IsNil() {
+		e.buf = append(e.buf, 0x0a)
+		return nil
 	}
 
-	return buf
+	e.buf = appendBytes(e.buf, v.Bytes())
+	return nil
 }
 
-func encode(buf []byte, d interface{}) ([]byte, error) {
-	switch v := d.(type) {
-	case string:
-		b, err := encode(nil, len([]byte(v)))
-		if err != nil {
-			return nil, err
-		}
-		b = append(b, []byte(v)...)
-		return appendItem(buf, 0x02, b), nil
-	case int8:
-		b := make([]byte, 1)
-		b[0] = byte(v)
-		return appendItem(buf, 0x03, b), nil
-	case int16:
-		b := make([]byte, 2)
-		order.PutUint16(b, uint16(v))
-		return appendItem(buf, 0x04, b), nil
-	case int32:
-		b := make([]byte, 4)
-		order.PutUint32(b, uint32(v))
-		return appendItem(buf, 0x05, b), nil
-	case int64:
-		b := make([]byte, 8)
-		order.PutUint64(b, uint64(v))
-		return appendItem(buf, 0x06, b), nil
-	case int:
-		return encode(buf, fitInt(v))
-	case bool:
-		if v {
-			return appendItem(buf, 0x08, nil), nil
+func intEncoder(m byte) encoderFunc {
+	return func(e *encodeState, v reflect.Value) error {
+		e.buf = appendInt(e.buf, v.Int(), m)
+		return nil
+	}
+}
+
+func fitIntEncoder(e *encodeState, v reflect.Value) error {
+	i := int(v.Int())
+	e.buf = appendInt(e.buf, int64(i), fitInt(i))
+	return nil
+}
+
+func boolEncoder(e *encodeState, v reflect.Value) error {
+	if v.Bool() {
+		e.buf = append(e.buf, 0x08)
+	} else {
+		e.buf = append(e.buf, 0x09)
+	}
+	return nil
+}
+
+func realEncoder(e *encodeState, v reflect.Value) error {
+	e.buf = append(e.buf, 0x07)
+	e.buf = appendUint64(e.buf, math.Float64bits(v.Float()))
+	return nil
+}
+
+func indirectEncoder(e *encodeState, v reflect.Value) error {
+	if v.IsNil() {
+		e.buf = append(e.buf, 0x0a)
+		return nil
+	}
+
+	return e.reflectValue(v.Elem())
+}
+
+func unsupportedTypeEncoder(e *encodeState, v reflect.Value) error {
+	return fmt.Errorf("Unsupported type: %s", v.Type().Kind())
+}
+
+func newArrayEncoder(t reflect.Type) encoderFunc {
+	var (
+		elemEnc  = typeEncoder(t.Elem())
+		tmplEnc  encoderFunc
+		nillable = isNillable(t.Kind())
+	)
+
+	switch elem := t.Elem(); {
+	case elem.Kind() == reflect.Struct || (elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct):
+		tmplEnc = newTemplateEncoder(t)
+	case elem.Kind() == reflect.Map && elem.Key().Kind() == reflect.String:
+		tmplEnc = newMapTemplateEncoder(t)
+	}
+
+	return func(e *encodeState, v reflect.Value) error {
+		if nillable && v.IsNil() {
+			e.buf = append(e.buf, 0x0a)
+			return nil
 		}
-		return appendItem(buf, 0x09, nil), nil
-	default:
-		r := reflect.ValueOf(d)
-		if isNillable(r.Kind()) && r.IsNil() {
-			return appendItem(buf, 0x0A, nil), nil
+
+		if tmplEnc != nil && e.templates != TemplateNever && canTemplateEncode(v) {
+			return tmplEnc(e, v)
 		}
 
-		if r.Type().Implements(typMarshaler) {
-			m := r.Interface().(Marshaler)
-			b, err := m.MarshalBSER()
-			if err != nil {
-				return nil, err
+		n := v.Len()
+		e.buf = append(e.buf, 0x00)
+		e.buf = appendInt(e.buf, int64(n), fitInt(n))
+		for i := 0; i < n; i++ {
+			if err := elemEnc(e, v.Index(i)); err != nil {
+				return err
 			}
-			return append(buf, b...), nil
 		}
+		return nil
+	}
+}
 
-		elem := false
-		for r.Kind() == reflect.Ptr || r.Kind() == reflect.Interface {
-			r = r.Elem()
-			elem = true
-		}
+func newStructEncoder(t reflect.Type) encoderFunc {
+	var (
+		sfields = fields(t).list
+		encs    = make([]encoderFunc, len(sfields))
+	)
 
-		if elem {
-			return encode(buf, r.Interface())
-		}
+	for i, f := range sfields {
+		encs[i] = fieldEncoder(f)
+	}
 
-		switch r.Kind() {
-		case reflect.String:
-			return encode(buf, r.String())
-		case reflect.Int8:
-			return encode(buf, int8(r.Int()))
-		case reflect.Int16:
-			return encode(buf, int16(r.Int()))
-		case reflect.Int32:
-			return encode(buf, int32(r.Int()))
-		case reflect.Int64:
-			return encode(buf, r.Int())
-		case reflect.Int:
-			return encode(buf, int(r.Int()))
-		case reflect.Bool:
-			return encode(buf, r.Bool())
-		case reflect.Float32:
-			b := make([]byte, 8)
-			order.PutUint64(b, math.Float64bits(r.Float()))
-			return appendItem(buf, 0x07, b), nil
-		case reflect.Float64:
-			b := make([]byte, 8)
-			order.PutUint64(b, math.Float64bits(r.Float()))
-			return appendItem(buf, 0x07, b), nil
-		case reflect.Slice, reflect.Array:
-			if canTemplateEncode(r) {
-				return encodeTemplate(buf, r)
+	return func(e *encodeState, v reflect.Value) error {
+		// fields promoted through nil embedded pointers and empty
+		// omitempty fields are skipped, so count them up front
+		n := 0
+		for _, f := range sfields {
+			if fv, ok := fieldByIndexNoAlloc(v, f.Index); ok && !(f.OmitEmpty && isEmptyValue(fv)) {
+				n++
 			}
+		}
 
-			b, err := encode(nil, r.Len())
-			if err != nil {
-				return nil, err
+		e.buf = append(e.buf, 0x01)
+		e.buf = appendInt(e.buf, int64(n), fitInt(n))
+		for i, f := range sfields {
+			fv, ok := fieldByIndexNoAlloc(v, f.Index)
+			if !ok || (f.OmitEmpty && isEmptyValue(fv)) {
+				continue
 			}
-			for i := 0; i < r.Len(); i++ {
-				// TODO: special behaviour for slice of templated objects: https://facebook.github.io/watchman/docs/bser.html#array-of-templated-objects
-				if b, err = encode(b, r.Index(i).Interface()); err != nil {
-					return nil, err
-				}
-			}
-			return appendItem(buf, 0x00, b), nil
-		case reflect.Struct:
-			exportedFields, err := exportedStructFields(r.Type())
-			if err != nil {
-				return nil, err
-			}
-			b, err := encode(nil, len(exportedFields))
-			if err != nil {
-				return nil, err
+
+			e.buf = appendString(e.buf, f.Name)
+			if err := encs[i](e, fv); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
+}
+
+// fieldEncoder returns the encoderFunc for the struct field f,
+// applying the unit from its tag to time fields and pointers to them
+func fieldEncoder(f field) encoderFunc {
+	if f.Unit != 0 && isTime(f.Type) {
+		return unitEncoder(f.Type, f.Unit)
+	}
+
+	return typeEncoder(f.Type)
+}
 
-			for _, field := range exportedFields {
-				// todo(isao) - need to handle embedded structs
-				b, err = encode(b, field.Name)
-				if err != nil {
-					return nil, err
+func newMapEncoder(t reflect.Type) encoderFunc {
+	var (
+		keyEnc   = typeEncoder(t.Key())
+		elemEnc  = typeEncoder(t.Elem())
+		sortable = t.Key().Kind() == reflect.String
+	)
+
+	return func(e *encodeState, v reflect.Value) error {
+		if v.IsNil() {
+			e.buf = append(e.buf, 0x0a)
+			return nil
+		}
+
+		n := v.Len()
+		e.buf = append(e.buf, 0x01)
+		e.buf = appendInt(e.buf, int64(n), fitInt(n))
+
+		if sortable && !e.unsortedKeys {
+			keys := v.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, k := range keys {
+				if err := keyEnc(e, k); err != nil {
+					return err
 				}
-				fv := r.FieldByIndex(field.Index)
-				b, err = encode(b, fv.Interface())
-				if err != nil {
-					return nil, err
+				if err := elemEnc(e, v.MapIndex(k)); err != nil {
+					return err
 				}
 			}
-			return appendItem(buf, 0x01, b), nil
-		case reflect.Map:
-			num := r.Len()
-			b, err := encode(nil, num)
-			if err != nil {
-				return nil, err
-			}
+			return nil
+		}
 
-			for _, k := range r.MapKeys() {
-				v := r.MapIndex(k)
-				b, err = encode(b, k.Interface())
-				if err != nil {
-					return nil, err
-				}
-				b, err = encode(b, v.Interface())
-				if err != nil {
-					return nil, err
-				}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := keyEnc(e, iter.Key()); err != nil {
+				return err
+			}
+			if err := elemEnc(e, iter.Value()); err != nil {
+				return err
 			}
-			return appendItem(buf, 0x01, b), nil
-		default:
-			return nil, fmt.Errorf("Unsupported type: %s", r.Type().Kind())
 		}
+		return nil
 	}
 }
 
@@ -211,6 +417,17 @@ func canTemplateEncode(r reflect.Value) bool {
 		return false
 	}
 	elem := r.Type().Elem()
+	if elem.Kind() == reflect.Map {
+		// slice/array of maps, which are templated by newMapTemplateEncoder
+		// once it has compared their keys
+		for i := 0; i < r.Len(); i++ {
+			if r.Index(i).IsNil() {
+				return false
+			}
+		}
+		return true
+	}
+
 	if elem.Kind() == reflect.Struct {
 		// slice/array of structs
 		return true
@@ -229,9 +446,9 @@ func canTemplateEncode(r reflect.Value) bool {
 	return false
 }
 
-func encodeTemplate(buf []byte, r reflect.Value) ([]byte, error) {
+func newTemplateEncoder(t reflect.Type) encoderFunc {
 	var (
-		elem  = r.Type().Elem()
+		elem  = t.Elem()
 		isPtr = false
 	)
 
@@ -240,61 +457,202 @@ func encodeTemplate(buf []byte, r reflect.Value) ([]byte, error) {
 		isPtr = true
 	}
 
-	exportedStructFields, err := exportedStructFields(elem)
-	if err != nil {
-		return nil, err
+	var (
+		sfields = fields(elem).list
+		encs    = make([]encoderFunc, len(sfields))
+		header  []byte
+	)
+
+	for i, f := range sfields {
+		encs[i] = fieldEncoder(f)
 	}
-	fieldNames := make([]string, len(exportedStructFields))
 
-	b, err := encode(nil, r.Len())
-	if err != nil {
-		return nil, err
+	header = append(header, 0x00)
+	header = appendInt(header, int64(len(sfields)), fitInt(len(sfields)))
+	for _, f := range sfields {
+		header = appendString(header, f.Name)
 	}
-	for i := 0; i < r.Len(); i++ {
-		s := r.Index(i)
-		if isPtr {
-			s = s.Elem()
-		}
-		for j, field := range exportedStructFields {
-			if i == 0 {
-				fieldNames[j] = field.Name
+
+	return func(e *encodeState, v reflect.Value) error {
+		n := v.Len()
+		e.buf = append(e.buf, 0x0b)
+		e.buf = append(e.buf, header...)
+		e.buf = appendInt(e.buf, int64(n), fitInt(n))
+
+		for i := 0; i < n; i++ {
+			s := v.Index(i)
+			if isPtr {
+				s = s.Elem()
 			}
-			fv := s.FieldByIndex(field.Index)
-			b, err = encode(b, fv.Interface())
-			if err != nil {
-				return nil, err
+			for j, f := range sfields {
+				// fields promoted through nil embedded pointers, nil
+				// pointers and empty omitempty fields are missing
+				fv, ok := fieldByIndexNoAlloc(s, f.Index)
+				if !ok || (fv.Kind() == reflect.Ptr && fv.IsNil()) || (f.OmitEmpty && isEmptyValue(fv)) {
+					e.buf = append(e.buf, 0x0c)
+					continue
+				}
+				if err := encs[j](e, fv); err != nil {
+					return err
+				}
 			}
 		}
+		return nil
 	}
-	b2, err := encode(buf, fieldNames)
-	if err != nil {
-		return nil, err
-	}
+}
 
-	b = append(b2, b...)
+// newMapTemplateEncoder returns an encoderFunc for slices of maps with
+// string keys that encodes the union of their keys as the template
+// field names, or falls back to a plain array when templating does not
+// apply in the encode's TemplateMode
+func newMapTemplateEncoder(t reflect.Type) encoderFunc {
+	var (
+		keyType = t.Elem().Key()
+		elemEnc = typeEncoder(t.Elem().Elem())
+	)
 
-	return appendItem(buf, 0x0b, b), nil
-}
+	return func(e *encodeState, v reflect.Value) error {
+		n := v.Len()
+		names, same := mapTemplateNames(v)
+		if e.templates != TemplateAlways && (n < 2 || !same) {
+			e.buf = append(e.buf, 0x00)
+			e.buf = appendInt(e.buf, int64(n), fitInt(n))
+			for i := 0; i < n; i++ {
+				if err := e.reflectValue(v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		keys := make([]reflect.Value, len(names))
+		for i, name := range names {
+			keys[i] = reflect.ValueOf(name).Convert(keyType)
+		}
 
-func exportedStructFields(t reflect.Type) ([]reflect.StructField, error) {
-	if t.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("can't get struct fields from value of kind: %s", t.Kind())
+		e.buf = append(e.buf, 0x0b, 0x00)
+		e.buf = appendInt(e.buf, int64(len(names)), fitInt(len(names)))
+		for _, name := range names {
+			e.buf = appendString(e.buf, name)
+		}
+		e.buf = appendInt(e.buf, int64(n), fitInt(n))
+
+		for i := 0; i < n; i++ {
+			m := v.Index(i)
+			for _, key := range keys {
+				fv := m.MapIndex(key)
+				if !fv.IsValid() {
+					e.buf = append(e.buf, 0x0c)
+					continue
+				}
+				if err := elemEnc(e, fv); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
 	}
+}
 
+// mapTemplateNames returns the sorted union of the keys of the maps in
+// v, and whether every map has all of them
+func mapTemplateNames(v reflect.Value) ([]string, bool) {
 	var (
-		exported  = []reflect.StructField{}
-		numFields = t.NumField()
+		seen  = map[string]bool{}
+		names []string
+		total int
 	)
 
-	for i := 0; i < numFields; i++ {
-		field := t.Field(i)
-		// PkgPath empty for exported struct fields - https://golang.org/pkg/reflect/#StructField
-		if field.PkgPath == "" {
-			exported = append(exported, field)
+	for i := 0; i < v.Len(); i++ {
+		m := v.Index(i)
+		total += m.Len()
+
+		iter := m.MapRange()
+		for iter.Next() {
+			name := iter.Key().String()
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names, total == len(names)*v.Len()
+}
+
+// fieldByIndexNoAlloc is reflect.Value.FieldByIndex but reports false
+// instead of panicking when it encounters a nil embedded pointer
+func fieldByIndexNoAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	if len(index) == 1 {
+		return v.Field(index[0]), true
+	}
+
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
 		}
+		v = v.Field(x)
 	}
+	return v, true
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, 0x02)
+	buf = appendInt(buf, int64(len(s)), fitInt(len(s)))
+	return append(buf, s...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = append(buf, 0x02)
+	buf = appendInt(buf, int64(len(b)), fitInt(len(b)))
+	return append(buf, b...)
+}
 
-	return exported, nil
+// appendInt appends v as an integer with the width of marker m
+func appendInt(buf []byte, v int64, m byte) []byte {
+	buf = append(buf, m)
+	switch m {
+	case 0x03:
+		return append(buf, byte(v))
+	case 0x04:
+		return append(buf, byte(v), byte(v>>8))
+	case 0x05:
+		return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	default:
+		return appendUint64(buf, uint64(v))
+	}
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+// isEmptyValue reports whether v is empty for the omitempty tag option,
+// as encoding/json defines it
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == typTime {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
 }
 
 func isNillable(k reflect.Kind) bool {
@@ -306,15 +664,16 @@ func isNillable(k reflect.Kind) bool {
 	}
 }
 
-func fitInt(v int) interface{} {
+// fitInt returns the marker of the smallest int type that can hold v
+func fitInt(v int) byte {
 	switch {
 	case v < math.MaxInt8 && v > math.MinInt8:
-		return int8(v)
+		return 0x03
 	case v < math.MaxInt16 && v > math.MinInt16:
-		return int16(v)
+		return 0x04
 	case v < math.MaxInt32 && v > math.MinInt32:
-		return int32(v)
+		return 0x05
 	default:
-		return int64(v)
+		return 0x06
 	}
 }
@@ -31,6 +31,22 @@ type superperson2 struct {
 	Power string
 }
 
+type taggedPerson struct {
+	Name string `bser:"name"`
+	Age  int    `bser:"age"`
+	Skip string `bser:"-"`
+}
+
+type team struct {
+	Lead    person
+	Members []person
+}
+
+type tree struct {
+	Name     string
+	Children []*tree
+}
+
 type unencodable struct {
 	C chan string
 }
@@ -363,6 +379,53 @@ var encodeTests = map[string]encodeTest{
 			"\x00\x01\x03\x1c\x01\x03\x01\x02\x03\x06Values\x00\x03\x02\x02\x03\x02ok\x02\x03\x05there",
 		),
 	},
+	"tagged_object": {
+		data: taggedPerson{Name: "fred", Age: 20, Skip: "skipped"},
+		expectedEnc: []byte(
+			"\x00\x01\x03\x19\x01\x03\x02\x02\x03\x04name\x02\x03\x04fred\x02\x03\x03age\x03\x14",
+		),
+	},
+	"embedded_struct": {
+		data: superperson{Person: Person{Name: "fred", Age: 12}, Power: "eating"},
+		// embedded fields are flattened into the outer object
+		expectedEnc: []byte(
+			"\x00\x01\x03\x2a\x01\x03\x03\x02\x03\x05Power\x02\x03\x06eating\x02\x03\x04Name\x02\x03\x04fred\x02\x03\x03Age\x03\x0c",
+		),
+	},
+	"duplicate_name": {
+		data: struct {
+			A string `bser:"x"`
+			B string `bser:"x"`
+		}{A: "a", B: "b"},
+		// the later field of a name is encoded, as it is decoded
+		expectedEnc: []byte(
+			"\x00\x01\x03\x0b\x01\x03\x01\x02\x03\x01x\x02\x03\x01b",
+		),
+	},
+	"nil_embedded_ptr_struct": {
+		data: superperson2{Power: "eating"},
+		expectedEnc: []byte(
+			"\x00\x01\x03\x14\x01\x03\x01\x02\x03\x05Power\x02\x03\x06eating",
+		),
+	},
+	"nested_template": {
+		data: team{Lead: person{Name: "fred", Age: 20}, Members: []person{{Name: "pete", Age: 30}}},
+		expectedEnc: []byte(
+			"\x00\x01\x03\x49\x01\x03\x02\x02\x03\x04Lead\x01\x03\x02\x02\x03\x04Name\x02\x03\x04fred\x02\x03\x03Age\x03\x14" +
+				"\x02\x03\x07Members\x0b\x00\x03\x02\x02\x03\x04Name\x02\x03\x03Age\x03\x01\x02\x03\x04pete\x03\x1e",
+		),
+	},
+	"recursive_type": {
+		data: tree{Name: "a", Children: []*tree{{Name: "b"}}},
+		expectedEnc: []byte(
+			"\x00\x01\x03\x36\x01\x03\x02\x02\x03\x04Name\x02\x03\x01a\x02\x03\x08Children" +
+				"\x0b\x00\x03\x02\x02\x03\x04Name\x02\x03\x08Children\x03\x01\x02\x03\x01b\x0a",
+		),
+	},
+	"nil_value": {
+		data:        nil,
+		expectedEnc: []byte("\x00\x01\x03\x01\x0a"),
+	},
 	"custom_marshaller": {
 		data:        customEncoding("123"),
 		expectedEnc: []byte("\x00\x01\x03\x02\x03\x7b"), // int instead of string due to custom marshal function
@@ -374,6 +437,12 @@ var encodeTests = map[string]encodeTest{
 		},
 		expectedEnc: []byte("\x00\x01\x03\x10\x01\x03\x02\x02\x03\x01a\x03{\x02\x03\x01b\x04\xc8\x01"),
 	},
+	"map_sorted_keys": {
+		data: map[string]int{"c": 3, "a": 1, "d": 4, "b": 2},
+		expectedEnc: []byte(
+			"\x00\x01\x03\x1b\x01\x03\x04\x02\x03\x01a\x03\x01\x02\x03\x01b\x03\x02\x02\x03\x01c\x03\x03\x02\x03\x01d\x03\x04",
+		),
+	},
 	"custom_marshaller_fails": {
 		data:      customEncoding("abc"), // custom marshal returns error if can't convert string to int
 		expectErr: true,
@@ -385,6 +454,18 @@ var encodeTests = map[string]encodeTest{
 		},
 		expectErr: true,
 	},
+	"byte_slice": {
+		data: []byte("a\xffb"),
+		expectedEnc: []byte(
+			"\x00\x01\x03\x06\x02\x03\x03a\xffb",
+		),
+	},
+	"nil_byte_slice": {
+		data: []byte(nil),
+		expectedEnc: []byte(
+			"\x00\x01\x03\x01\x0a",
+		),
+	},
 	"chan_slice": {
 		data: []chan string{
 			make(chan string),
@@ -668,3 +749,192 @@ func BenchmarkEncodeStructArr(b *testing.B) {
 		})
 	}
 }
+
+func BenchmarkEncodeFiles(b *testing.B) {
+	for _, numFiles := range payloadSizes {
+		files := make([]benchFile, numFiles)
+		for i := range files {
+			files[i] = benchFile{
+				Name:   fmt.Sprintf("src/github.com/jonasi/watchman/pkg%d/file_%d.go", i%50, i),
+				Size:   4096 + i,
+				Mode:   0100644,
+				Mtime:  1577836800 + i,
+				Exists: true,
+				Oclock: fmt.Sprintf("c:1577836800:1234:1:%d", i),
+			}
+		}
+
+		b.Run(fmt.Sprintf("num_files=%d", numFiles), func(b *testing.B) {
+			var (
+				buf bytes.Buffer
+				enc = NewEncoder(&buf)
+				err error
+			)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				err = enc.Encode(files)
+			}
+			benchEncErr = err
+		})
+	}
+}
+
+type omitFile struct {
+	Name  string  `bser:"name"`
+	Size  int     `bser:"size,omitempty"`
+	Owner *string `bser:"owner"`
+}
+
+func TestTemplateMode(t *testing.T) {
+	var (
+		owner  = "root"
+		shared = []map[string]interface{}{{"a": 1, "b": "x"}, {"a": 2, "b": "y"}}
+		mixed  = []map[string]int{{"a": 1}, {"b": 2}}
+		files  = []omitFile{{Name: "a", Size: 1}, {Name: "b", Owner: &owner}}
+	)
+
+	var templateModeTests = map[string]struct {
+		mode     TemplateMode
+		value    interface{}
+		expected []byte
+	}{
+		"maps_shared_keys": {
+			mode:  TemplateAuto,
+			value: shared,
+			expected: concat(
+				AppendTemplateHeader(nil, []string{"a", "b"}, 2),
+				AppendInt(nil, 1), AppendString(nil, "x"),
+				AppendInt(nil, 2), AppendString(nil, "y"),
+			),
+		},
+		"maps_mixed_keys": {
+			mode:  TemplateAuto,
+			value: mixed,
+			expected: concat(
+				AppendArrayHeader(nil, 2),
+				AppendObjectHeader(nil, 1), AppendString(nil, "a"), AppendInt(nil, 1),
+				AppendObjectHeader(nil, 1), AppendString(nil, "b"), AppendInt(nil, 2),
+			),
+		},
+		"maps_mixed_keys_always": {
+			mode:  TemplateAlways,
+			value: mixed,
+			expected: concat(
+				AppendTemplateHeader(nil, []string{"a", "b"}, 2),
+				AppendInt(nil, 1), AppendMissing(nil),
+				AppendMissing(nil), AppendInt(nil, 2),
+			),
+		},
+		"maps_single_always": {
+			mode:  TemplateAlways,
+			value: []map[string]int{{"a": 1}},
+			expected: concat(
+				AppendTemplateHeader(nil, []string{"a"}, 1),
+				AppendInt(nil, 1),
+			),
+		},
+		"maps_nil_always": {
+			mode:  TemplateAlways,
+			value: []map[string]int{{"a": 1}, nil},
+			expected: concat(
+				AppendArrayHeader(nil, 2),
+				AppendObjectHeader(nil, 1), AppendString(nil, "a"), AppendInt(nil, 1),
+				AppendNull(nil),
+			),
+		},
+		"structs_missing": {
+			mode:  TemplateAuto,
+			value: files,
+			expected: concat(
+				AppendTemplateHeader(nil, []string{"name", "size", "owner"}, 2),
+				AppendString(nil, "a"), AppendInt(nil, 1), AppendMissing(nil),
+				AppendString(nil, "b"), AppendMissing(nil), AppendString(nil, "root"),
+			),
+		},
+		"structs_never": {
+			mode:  TemplateNever,
+			value: files,
+			expected: concat(
+				AppendArrayHeader(nil, 2),
+				AppendObjectHeader(nil, 3),
+				AppendString(nil, "name"), AppendString(nil, "a"),
+				AppendString(nil, "size"), AppendInt(nil, 1),
+				AppendString(nil, "owner"), AppendNull(nil),
+				AppendObjectHeader(nil, 2),
+				AppendString(nil, "name"), AppendString(nil, "b"),
+				AppendString(nil, "owner"), AppendString(nil, "root"),
+			),
+		},
+	}
+
+	for testName, testCase := range templateModeTests {
+		t.Run(testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetTemplateMode(testCase.mode)
+			if err := enc.Encode(testCase.value); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual, err := NewPDUReader(&buf).ReadPDU(nil)
+			if err != nil {
+				t.Fatalf("unexpected error reading PDU: %s", err)
+			}
+
+			if !bytes.Equal(testCase.expected, actual) {
+				t.Fatalf("unexpected encoded data:\n\nexpected = %v\n\nactual = %v", testCase.expected, actual)
+			}
+		})
+	}
+
+	// missing values decode back to the zero value
+	b, err := MarshalValue(files)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var decoded []omitFile
+	if err := UnmarshalValue(b, &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(decoded) != 2 || decoded[0] != files[0] || decoded[1].Owner == nil || *decoded[1].Owner != owner {
+		t.Fatalf("unexpected decoded value: %#v", decoded)
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestEncoderSortMapKeys(t *testing.T) {
+	m := map[string]int{}
+	for i := 0; i < 50; i++ {
+		m[strconv.Itoa(i)] = i
+	}
+
+	encode := func(sorted bool) []byte {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetSortMapKeys(sorted)
+		if err := enc.Encode(m); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.Bytes()
+	}
+
+	expected := encode(true)
+	for i := 0; i < 10; i++ {
+		if actual := encode(true); !bytes.Equal(expected, actual) {
+			t.Fatalf("sorted encodings differ:\n\nexpected = %v\n\nactual = %v", expected, actual)
+		}
+	}
+
+	var decoded map[string]int
+	if err := UnmarshalPDU(encode(false), &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(decoded) != len(m) {
+		t.Fatalf("expected %d keys, found %d", len(m), len(decoded))
+	}
+}
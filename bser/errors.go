@@ -0,0 +1,122 @@
+package bser
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// A SyntaxError describes malformed BSER data
+type SyntaxError struct {
+	msg string
+
+	// Offset is the byte offset into the value, not counting the
+	// PDU header, at which the error was found
+	Offset int
+	// Type is the byte found at Offset, the type marker of the value
+	// at fault for most errors. If the data ends early, Offset is the
+	// end of the data and Type is the marker of the value cut short.
+	Type Type
+	// Field is the path of the value being decoded, such as files[12].mtime
+	Field string
+}
+
+func (e *SyntaxError) Error() string {
+	return describeError(e.msg, e.Offset, e.Field)
+}
+
+// An UnmarshalTypeError describes a BSER value that could not be
+// stored in a value of a specific Go type
+type UnmarshalTypeError struct {
+	// Value is the BSER type of the value
+	Value Type
+	// Type is the Go type it could not be stored in
+	Type reflect.Type
+	// Offset is the byte offset into the value, not counting the
+	// PDU header, of the value's type marker
+	Offset int
+	// Field is the path of the value being decoded, such as files[12].mtime
+	Field string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return describeError(fmt.Sprintf("can't decode %s to %s", e.Value, e.Type), e.Offset, e.Field)
+}
+
+// An UnknownFieldError describes an object key that matched no field of
+// the struct being decoded, when unknown fields are disallowed
+type UnknownFieldError struct {
+	// Name is the object key, or the templated array field name
+	Name string
+	// Type is the struct type, if known. It is nil for a Cursor.
+	Type reflect.Type
+	// Offset is the byte offset into the value, not counting the
+	// PDU header, of the key's value, or of the templated array's
+	// type marker for a templated array field
+	Offset int
+	// Field is the path of the struct being decoded, such as files[12]
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	msg := fmt.Sprintf("unknown field %q", e.Name)
+	if e.Type != nil {
+		msg += " in " + e.Type.String()
+	}
+
+	return describeError(msg, e.Offset, e.Field)
+}
+
+func describeError(msg string, off int, field string) string {
+	if field == "" {
+		return fmt.Sprintf("%s at offset %d", msg, off)
+	}
+
+	return fmt.Sprintf("%s at offset %d in field %s", msg, off, field)
+}
+
+// addPath prefixes the field path of a decode error with seg,
+// an object key or an array index formatted by indexPath
+func addPath(err error, seg string) error {
+	var field *string
+	switch e := err.(type) {
+	case *SyntaxError:
+		field = &e.Field
+	case *UnmarshalTypeError:
+		field = &e.Field
+	case *UnknownFieldError:
+		field = &e.Field
+	default:
+		return err
+	}
+
+	switch {
+	case *field == "":
+		*field = seg
+	case (*field)[0] == '[':
+		*field = seg + *field
+	default:
+		*field = seg + "." + *field
+	}
+
+	return err
+}
+
+func indexPath(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
+
+// addOffset shifts the offset of a decode error by off, used when
+// an error comes from decoding a sub slice of the data
+func addOffset(err error, off int) error {
+	switch e := err.(type) {
+	case *SyntaxError:
+		e.Offset += off
+	case *UnmarshalTypeError:
+		e.Offset += off
+	case *UnknownFieldError:
+		e.Offset += off
+	}
+
+	return err
+}
@@ -0,0 +1,509 @@
+package bser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ToJSON reads BSER PDUs from r and writes each value to w as a line of
+// JSON. Integers are written without a fractional part and reals always
+// with one, so FromJSON restores the distinction. Templated arrays are
+// expanded to arrays of objects, leaving out missing fields. Strings are
+// written as UTF-8, with each byte that isn't valid UTF-8 replaced by
+// U+FFFD as encoding/json does, so byte strings holding such data, like
+// some file names, can't be restored from the JSON.
+func ToJSON(w io.Writer, r io.Reader) error {
+	var (
+		pr  = NewPDUReader(r)
+		pdu []byte
+		buf []byte
+		err error
+	)
+
+	for {
+		if pdu, err = pr.ReadPDU(pdu[:0]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if buf, err = AppendJSON(buf[:0], pdu); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(append(buf, '\n')); err != nil {
+			return err
+		}
+	}
+}
+
+// FromJSON reads a stream of JSON values from r and writes each to w as a
+// BSER PDU. Numbers without a fraction or exponent are encoded as the
+// smallest integer type that holds them and all others as reals. Strings
+// are encoded as byte strings. Arrays of two or more objects, and
+// nothing else, are encoded as templated arrays: the field names are the
+// keys of all the objects in the order they are first seen, and a key an
+// object lacks is encoded as missing. Any array of objects in the JSON,
+// including one in a command's arguments, is converted this way, so
+// values whose encoding matters should be written with an Encoder.
+func FromJSON(w io.Writer, r io.Reader) error {
+	var (
+		dec = json.NewDecoder(r)
+		pw  = NewPDUWriter(w)
+		buf []byte
+	)
+
+	for {
+		var js json.RawMessage
+		if err := dec.Decode(&js); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var err error
+		if buf, err = AppendFromJSON(buf[:0], js); err != nil {
+			return err
+		}
+
+		if err := pw.WritePDU(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// AppendJSON appends the JSON encoding of the BSER value b to buf,
+// as ToJSON does
+func AppendJSON(buf []byte, b []byte) ([]byte, error) {
+	d := decodeState{data: b}
+	buf, err := d.appendJSON(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.off != len(d.data) {
+		return nil, d.syntaxError(d.off, "unexpected data after value")
+	}
+
+	return buf, nil
+}
+
+func (d *decodeState) appendJSON(buf []byte) ([]byte, error) {
+	m, err := d.marker()
+	if err != nil {
+		return nil, err
+	}
+	d.m = m
+
+	switch m {
+	case 0x00:
+		if err := d.push(d.off - 1); err != nil {
+			return nil, err
+		}
+
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, '[')
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if buf, err = d.appendJSON(buf); err != nil {
+				return nil, addPath(err, indexPath(i))
+			}
+		}
+
+		d.pop()
+		return append(buf, ']'), nil
+	case 0x01:
+		if err := d.push(d.off - 1); err != nil {
+			return nil, err
+		}
+
+		n, err := d.count()
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, '{')
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+
+			key, err := d.key()
+			if err != nil {
+				return nil, err
+			}
+
+			buf = appendJSONString(buf, key)
+			buf = append(buf, ':')
+			if buf, err = d.appendJSON(buf); err != nil {
+				return nil, addPath(err, string(key))
+			}
+		}
+
+		d.pop()
+		return append(buf, '}'), nil
+	case 0x02, 0x0d:
+		b, err := d.stringBody()
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(buf, b), nil
+	case 0x03, 0x04, 0x05, 0x06:
+		v, err := d.int(m)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(buf, v, 10), nil
+	case 0x07:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+
+		f := math.Float64frombits(order.Uint64(b))
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, d.syntaxError(d.off-9, fmt.Sprintf("unsupported real value %v", f))
+		}
+
+		start := len(buf)
+		buf = strconv.AppendFloat(buf, f, 'g', -1, 64)
+		if bytes.IndexAny(buf[start:], ".e") == -1 {
+			buf = append(buf, ".0"...)
+		}
+		return buf, nil
+	case 0x08:
+		return append(buf, "true"...), nil
+	case 0x09:
+		return append(buf, "false"...), nil
+	case 0x0a:
+		return append(buf, "null"...), nil
+	case 0x0b:
+		return d.appendTemplateJSON(buf)
+	default:
+		return nil, d.syntaxError(d.off-1, fmt.Sprintf("invalid type marker %x", m))
+	}
+}
+
+func (d *decodeState) appendTemplateJSON(buf []byte) ([]byte, error) {
+	if err := d.push(d.off - 1); err != nil {
+		return nil, err
+	}
+
+	if m, err := d.marker(); err != nil {
+		return nil, err
+	} else if m != 0x00 {
+		return nil, d.syntaxError(d.off-1, fmt.Sprintf("template field names must be an array, found %s", Type(m)))
+	}
+
+	width, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+
+	// the field names, and the names encoded once as JSON object keys
+	var (
+		names = make([][]byte, width)
+		keys  = make([][]byte, width)
+	)
+	for i := range keys {
+		if names[i], err = d.stringBytes(); err != nil {
+			return nil, err
+		}
+		keys[i] = append(appendJSONString(nil, names[i]), ':')
+	}
+
+	n, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+
+	buf = append(buf, '[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = append(buf, '{')
+		first := true
+		for j, key := range keys {
+			if m, _ := d.peek(); m == 0x0c {
+				d.off++
+				continue
+			}
+
+			if !first {
+				buf = append(buf, ',')
+			}
+			first = false
+
+			buf = append(buf, key...)
+			if buf, err = d.appendJSON(buf); err != nil {
+				return nil, addPath(addPath(err, string(names[j])), indexPath(i))
+			}
+		}
+		buf = append(buf, '}')
+	}
+
+	d.pop()
+	return append(buf, ']'), nil
+}
+
+// appendJSONString appends s as a JSON string, replacing invalid UTF-8
+// with the Unicode replacement character as encoding/json does
+func appendJSONString(buf []byte, s []byte) []byte {
+	const hex = "0123456789abcdef"
+
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// AppendFromJSON appends the BSER encoding of the JSON value js to buf,
+// as FromJSON does
+func AppendFromJSON(buf []byte, js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	v, err := parseJSON(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+
+	return appendJSONValue(buf, v)
+}
+
+// jsonObject is a JSON object with its keys in their original order
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// parseJSON reads the next value from dec as a string, json.Number,
+// bool, nil, []interface{} or jsonObject
+func parseJSON(dec *json.Decoder, depth int) (interface{}, error) {
+	if depth > defaultMaxDepth {
+		return nil, fmt.Errorf("exceeded max depth %d", defaultMaxDepth)
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := parseJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := parseJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonMember{key.(string), v})
+		}
+		_, err := dec.Token()
+		return obj, err
+	default:
+		return tok, nil
+	}
+}
+
+func appendJSONValue(buf []byte, v interface{}) ([]byte, error) {
+	var err error
+
+	switch v := v.(type) {
+	case nil:
+		return AppendNull(buf), nil
+	case bool:
+		return AppendBool(buf, v), nil
+	case string:
+		return AppendString(buf, v), nil
+	case json.Number:
+		return appendJSONNumber(buf, v)
+	case jsonObject:
+		buf = AppendObjectHeader(buf, len(v))
+		for _, m := range v {
+			buf = AppendString(buf, m.key)
+			if buf, err = appendJSONValue(buf, m.value); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case []interface{}:
+		if names, ok := templateNames(v); ok {
+			return appendJSONTemplate(buf, names, v)
+		}
+
+		buf = AppendArrayHeader(buf, len(v))
+		for _, e := range v {
+			if buf, err = appendJSONValue(buf, e); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("Unsupported JSON value: %v", v)
+	}
+}
+
+func appendJSONNumber(buf []byte, n json.Number) ([]byte, error) {
+	if !strings.ContainsAny(string(n), ".eE") {
+		if i, err := n.Int64(); err == nil {
+			return appendInt(buf, i, fitInt64(i)), nil
+		}
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+
+	return AppendReal(buf, f), nil
+}
+
+// fitInt64 is fitInt for values that may not fit in an int
+func fitInt64(v int64) byte {
+	if int64(int(v)) != v {
+		return 0x06
+	}
+
+	return fitInt(int(v))
+}
+
+// templateNames returns the union of the keys of arr, in the order they
+// are first seen, if arr is two or more objects
+func templateNames(arr []interface{}) ([]string, bool) {
+	if len(arr) < 2 {
+		return nil, false
+	}
+
+	var (
+		names []string
+		seen  = map[string]bool{}
+	)
+
+	for _, e := range arr {
+		obj, ok := e.(jsonObject)
+		if !ok {
+			return nil, false
+		}
+
+		for _, m := range obj {
+			if !seen[m.key] {
+				seen[m.key] = true
+				names = append(names, m.key)
+			}
+		}
+	}
+
+	return names, true
+}
+
+func appendJSONTemplate(buf []byte, names []string, arr []interface{}) ([]byte, error) {
+	buf = AppendTemplateHeader(buf, names, len(arr))
+
+	for _, e := range arr {
+		obj := e.(jsonObject)
+		for _, name := range names {
+			v, ok := obj.get(name)
+			if !ok {
+				buf = append(buf, byte(TypeMissing))
+				continue
+			}
+
+			var err error
+			if buf, err = appendJSONValue(buf, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+// get returns the value of the last member named key, which
+// is the one encoding/json would keep
+func (o jsonObject) get(key string) (interface{}, bool) {
+	for i := len(o) - 1; i >= 0; i-- {
+		if o[i].key == key {
+			return o[i].value, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,104 @@
+package bser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	type file struct {
+		Name   string `bser:"name"`
+		Exists *bool  `bser:"exists"`
+	}
+
+	var toJSONTests = map[string]struct {
+		value    interface{}
+		encoded  []byte
+		expected string
+	}{
+		"ints":          {value: []interface{}{int8(1), int16(-300), int32(70000), int64(1) << 40}, expected: `[1,-300,70000,1099511627776]`},
+		"reals":         {value: []float64{1, 0.5, 1e21}, expected: `[1.0,0.5,1e+21]`},
+		"scalars":       {value: []interface{}{true, false, nil, "a\"b\n"}, expected: `[true,false,null,"a\"b\n"]`},
+		"object":        {value: struct{ A, B int }{1, 2}, expected: `{"A":1,"B":2}`},
+		"template":      {value: []file{{Name: "a"}, {Name: "b"}}, expected: `[{"name":"a"},{"name":"b"}]`},
+		"missing":       {encoded: []byte("\x0b\x00\x03\x02\x02\x03\x01a\x02\x03\x01b\x03\x02\x03\x01\x0c\x0c\x03\x02"), expected: `[{"a":1},{"b":2}]`},
+		"utf8_string":   {encoded: []byte("\x00\x03\x02\x0d\x03\x01a\x02\x03\x01b"), expected: `["a","b"]`},
+		"invalid_utf8":  {encoded: []byte("\x02\x03\x02\xff\x61"), expected: "\"\ufffda\""},
+		"control_chars": {value: "\x01", expected: `"\u0001"`},
+	}
+
+	for testName, testCase := range toJSONTests {
+		t.Run(testName, func(t *testing.T) {
+			encoded := testCase.encoded
+			if encoded == nil {
+				encoded = mustMarshalValue(testCase.value)
+			}
+
+			var pdus, out bytes.Buffer
+			if err := NewPDUWriter(&pdus).WritePDU(encoded); err != nil {
+				t.Fatalf("unexpected error writing PDU: %s", err)
+			}
+			if err := ToJSON(&out, &pdus); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if actual := out.String(); actual != testCase.expected+"\n" {
+				t.Fatalf("unexpected JSON:\n\nexpected = %s\n\nactual = %s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	var fromJSONTests = map[string]struct {
+		json     string
+		expected []byte
+	}{
+		"int":      {json: `1`, expected: AppendInt(nil, 1)},
+		"big_int":  {json: `1099511627776`, expected: AppendInt64(nil, 1<<40)},
+		"real":     {json: `1.0`, expected: AppendReal(nil, 1)},
+		"exponent": {json: `1e3`, expected: AppendReal(nil, 1000)},
+		"object":   {json: `{"b":true,"a":null}`, expected: AppendNull(AppendString(AppendBool(AppendString(AppendObjectHeader(nil, 2), "b"), true), "a"))},
+		"array":    {json: `[{"a":1}]`, expected: AppendInt(AppendString(AppendObjectHeader(AppendArrayHeader(nil, 1), 1), "a"), 1)},
+		"template": {json: `[{"a":1},{"b":"x"}]`, expected: AppendString(append(AppendInt(AppendTemplateHeader(nil, []string{"a", "b"}, 2), 1), 0x0c, 0x0c), "x")},
+	}
+
+	for testName, testCase := range fromJSONTests {
+		t.Run(testName, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := FromJSON(&out, strings.NewReader(testCase.json)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual, err := NewPDUReader(&out).ReadPDU(nil)
+			if err != nil {
+				t.Fatalf("unexpected error reading PDU: %s", err)
+			}
+			if !bytes.Equal(actual, testCase.expected) {
+				t.Fatalf("unexpected encoded data:\n\nexpected = %v\n\nactual = %v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	const js = `{"files":[{"name":"a","size":1,"mtime":1.5},{"name":"b","exists":false}],"clock":"c:1:2","version":"4.9.0"}
+[1,2.0,"three",null]
+`
+
+	var pdus, out bytes.Buffer
+	if err := FromJSON(&pdus, strings.NewReader(js)); err != nil {
+		t.Fatalf("unexpected error converting from JSON: %s", err)
+	}
+	if err := ToJSON(&out, &pdus); err != nil {
+		t.Fatalf("unexpected error converting to JSON: %s", err)
+	}
+
+	expected := `{"files":[{"name":"a","size":1,"mtime":1.5},{"name":"b","exists":false}],"clock":"c:1:2","version":"4.9.0"}
+[1,2.0,"three",null]
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected JSON:\n\nexpected = %s\n\nactual = %s", expected, out.String())
+	}
+}
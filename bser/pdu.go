@@ -0,0 +1,282 @@
+package bser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// the BSER v2 capability flags
+const (
+	// CapDisableUnicode indicates strings are not required to be valid UTF-8
+	CapDisableUnicode uint32 = 0x1
+	// CapDisableUnicodeForErrors indicates error messages are not required to be valid UTF-8
+	CapDisableUnicodeForErrors uint32 = 0x2
+)
+
+var protocolPrefixV2 = []byte{0, 2}
+
+// maxPDUHeader is the size of the largest PDU header: the v2 prefix,
+// the capabilities and an int64 length
+const maxPDUHeader = 2 + 4 + 9
+
+// preallocLimit is the largest PDU body allocated up front from the size
+// in its header. Larger bodies grow as data arrives, so a bogus header
+// can't force a huge allocation.
+const preallocLimit = 1 << 20
+
+const maxInt = int(^uint(0) >> 1)
+
+// PDUHeader describes the header that precedes each PDU
+type PDUHeader struct {
+	// Version is the BSER protocol version, 1 or 2
+	Version int
+	// Capabilities are the capability flags of a version 2 PDU
+	Capabilities uint32
+	// Length is the size of the PDU body in bytes
+	Length int
+	// Raw is the header as it was read
+	Raw []byte
+}
+
+// NewPDUReader returns a PDUReader reading from r
+func NewPDUReader(r io.Reader) *PDUReader {
+	return &PDUReader{r: r}
+}
+
+// PDUReader reads whole PDUs from a stream, accepting both version 1
+// and version 2 headers. Reads are retried until a PDU is complete,
+// so it is safe to use on sockets that return data in pieces.
+type PDUReader struct {
+	r       io.Reader
+	maxSize int
+	raw     [maxPDUHeader]byte
+	header  PDUHeader
+}
+
+// SetMaxSize limits the PDUs read to n bytes. A PDU whose header claims
+// a larger size is rejected before it is read, after which the input is
+// no longer usable. A value of 0 means no limit.
+func (p *PDUReader) SetMaxSize(n int) {
+	p.maxSize = n
+}
+
+// Header returns the header of the last PDU read. Its Raw bytes are
+// only valid until the next call to ReadPDU.
+func (p *PDUReader) Header() PDUHeader {
+	return p.header
+}
+
+// ReadPDU reads the next PDU and returns its body, reusing the
+// capacity of buf. It returns io.EOF if the input ends before a PDU
+// begins and io.ErrUnexpectedEOF if it ends part way through one.
+func (p *PDUReader) ReadPDU(buf []byte) ([]byte, error) {
+	hdr := p.raw[:0]
+
+	hdr, err := p.readFull(hdr, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	h := PDUHeader{}
+	switch {
+	case bytes.Equal(hdr, protocolPrefix):
+		h.Version = 1
+	case bytes.Equal(hdr, protocolPrefixV2):
+		h.Version = 2
+		if hdr, err = p.readFull(hdr, 4); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		h.Capabilities = order.Uint32(hdr[2:])
+	default:
+		return nil, fmt.Errorf("Expected %x or %x, found %x", protocolPrefix, protocolPrefixV2, hdr)
+	}
+
+	if hdr, err = p.readFull(hdr, 1); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	m := hdr[len(hdr)-1]
+	n := intSize(m)
+	if n == 0 {
+		return nil, fmt.Errorf("Invalid type marker found: %x", m)
+	}
+
+	if hdr, err = p.readFull(hdr, n); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	d := decodeState{data: hdr[len(hdr)-n:]}
+	size, err := d.int(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 || int64(int(size)) != size {
+		return nil, fmt.Errorf("Invalid PDU size: %d", size)
+	}
+
+	if p.maxSize > 0 && size > int64(p.maxSize) {
+		return nil, fmt.Errorf("PDU size %d exceeds maximum of %d", size, p.maxSize)
+	}
+
+	h.Length = int(size)
+	h.Raw = hdr
+	p.header = h
+
+	switch {
+	case cap(buf) >= h.Length:
+		buf = buf[:h.Length]
+	case h.Length <= preallocLimit:
+		buf = make([]byte, h.Length)
+	default:
+		b := bytes.NewBuffer(buf[:0])
+		if _, err := io.CopyN(b, p.r, size); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return b.Bytes(), nil
+	}
+
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return buf, nil
+}
+
+// SplitPDU is a bufio.SplitFunc that splits a stream into whole PDUs,
+// headers included
+func SplitPDU(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	hdr := len(protocolPrefix)
+	if len(data) >= 2 && bytes.Equal(data[:2], protocolPrefixV2) {
+		hdr += 4
+	} else if len(data) >= 2 && !bytes.Equal(data[:2], protocolPrefix) {
+		return 0, nil, fmt.Errorf("Expected %x or %x, found %x", protocolPrefix, protocolPrefixV2, data[:2])
+	}
+
+	if len(data) > hdr {
+		m := data[hdr]
+		n := intSize(m)
+		if n == 0 {
+			return 0, nil, fmt.Errorf("Invalid type marker found: %x", m)
+		}
+
+		if len(data) >= hdr+1+n {
+			d := decodeState{data: data[hdr+1 : hdr+1+n]}
+			size, err := d.int(m)
+			if err != nil {
+				return 0, nil, err
+			}
+			if size < 0 || size > int64(maxInt-hdr-1-n) {
+				return 0, nil, fmt.Errorf("Invalid PDU size: %d", size)
+			}
+
+			if total := hdr + 1 + n + int(size); len(data) >= total {
+				return total, data[:total], nil
+			}
+		}
+	}
+
+	if atEOF {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+
+	return 0, nil, nil
+}
+
+// readFull reads n more bytes onto the end of b
+func (p *PDUReader) readFull(b []byte, n int) ([]byte, error) {
+	l := len(b)
+	b = b[:l+n]
+	if _, err := io.ReadFull(p.r, b[l:]); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// unexpectedEOF converts io.EOF, which is only expected between PDUs
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// NewPDUWriter returns a PDUWriter writing version 1 PDUs to w
+func NewPDUWriter(w io.Writer) *PDUWriter {
+	return &PDUWriter{w: w, version: 1}
+}
+
+// NewPDUWriterV2 returns a PDUWriter writing version 2 PDUs
+// with the given capabilities to w
+func NewPDUWriterV2(w io.Writer, capabilities uint32) *PDUWriter {
+	return &PDUWriter{w: w, version: 2, capabilities: capabilities}
+}
+
+// PDUWriter frames encoded values as PDUs and writes each one
+// to the underlying stream with a single call to Write
+type PDUWriter struct {
+	w            io.Writer
+	version      int
+	capabilities uint32
+	buf          []byte
+}
+
+// WritePDU writes the encoded value b as a PDU
+func (p *PDUWriter) WritePDU(b []byte) error {
+	if cap(p.buf) < maxPDUHeader {
+		p.buf = make([]byte, maxPDUHeader, maxPDUHeader+len(b))
+	}
+
+	p.buf = append(p.buf[:maxPDUHeader], b...)
+	return p.writeReserved(p.buf)
+}
+
+// writeReserved writes the encoded value that follows maxPDUHeader
+// bytes reserved at the start of buf, filling in as much of the
+// reserved space as the header needs
+func (p *PDUWriter) writeReserved(buf []byte) error {
+	var (
+		raw    [maxPDUHeader]byte
+		header = p.appendHeader(raw[:0], len(buf)-maxPDUHeader)
+		start  = maxPDUHeader - len(header)
+	)
+
+	copy(buf[start:], header)
+
+	_, err := p.w.Write(buf[start:])
+	return err
+}
+
+func (p *PDUWriter) appendHeader(buf []byte, size int) []byte {
+	if p.version == 2 {
+		buf = append(buf, protocolPrefixV2...)
+		buf = append(buf, byte(p.capabilities), byte(p.capabilities>>8), byte(p.capabilities>>16), byte(p.capabilities>>24))
+	} else {
+		buf = append(buf, protocolPrefix...)
+	}
+
+	return appendInt(buf, int64(size), fitInt(size))
+}
+
+// intSize returns the number of bytes following int marker m
+func intSize(m byte) int {
+	switch m {
+	case 0x03:
+		return 1
+	case 0x04:
+		return 2
+	case 0x05:
+		return 4
+	case 0x06:
+		return 8
+	default:
+		return 0
+	}
+}
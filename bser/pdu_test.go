@@ -0,0 +1,128 @@
+package bser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPDUReader(t *testing.T) {
+	var pduReaderTests = map[string]struct {
+		encoded  []byte
+		expected []byte
+		header   PDUHeader
+		err      error
+	}{
+		"v1": {
+			encoded:  []byte("\x00\x01\x03\x02\x03\x2a"),
+			expected: []byte("\x03\x2a"),
+			header:   PDUHeader{Version: 1, Length: 2, Raw: []byte("\x00\x01\x03\x02")},
+		},
+		"v2": {
+			encoded:  []byte("\x00\x02\x03\x00\x00\x00\x05\x02\x00\x00\x00\x03\x2a"),
+			expected: []byte("\x03\x2a"),
+			header:   PDUHeader{Version: 2, Capabilities: CapDisableUnicode | CapDisableUnicodeForErrors, Length: 2, Raw: []byte("\x00\x02\x03\x00\x00\x00\x05\x02\x00\x00\x00")},
+		},
+		"empty": {
+			encoded: []byte{},
+			err:     io.EOF,
+		},
+		"truncated_header": {
+			encoded: []byte("\x00\x01\x05\x02"),
+			err:     io.ErrUnexpectedEOF,
+		},
+		"truncated_body": {
+			encoded: []byte("\x00\x01\x03\x07\x02\x03\x05he"),
+			err:     io.ErrUnexpectedEOF,
+		},
+	}
+
+	for testName, testCase := range pduReaderTests {
+		t.Run(testName, func(t *testing.T) {
+			// deliver the data a byte at a time to exercise short reads
+			r := NewPDUReader(iotest.OneByteReader(bytes.NewReader(testCase.encoded)))
+
+			b, err := r.ReadPDU(nil)
+			if err != testCase.err {
+				t.Fatalf("unexpected error: expected %v, found %v", testCase.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !bytes.Equal(b, testCase.expected) {
+				t.Fatalf("unexpected PDU body:\n\nexpected = %v\n\nactual = %v", testCase.expected, b)
+			}
+			if h := r.Header(); !reflect.DeepEqual(h, testCase.header) {
+				t.Fatalf("unexpected PDU header:\n\nexpected = %#v\n\nactual = %#v", testCase.header, h)
+			}
+		})
+	}
+}
+
+func TestPDUWriter(t *testing.T) {
+	var (
+		buf     bytes.Buffer
+		payload = bytes.Repeat([]byte("\x03\x2a"), 100)
+	)
+
+	if err := NewPDUWriter(&buf).WritePDU(payload); err != nil {
+		t.Fatalf("unexpected error writing v1 PDU: %s", err)
+	}
+	if err := NewPDUWriterV2(&buf, CapDisableUnicode).WritePDU(payload); err != nil {
+		t.Fatalf("unexpected error writing v2 PDU: %s", err)
+	}
+
+	r := NewPDUReader(&buf)
+	for _, version := range []int{1, 2} {
+		b, err := r.ReadPDU(nil)
+		if err != nil {
+			t.Fatalf("unexpected error reading v%d PDU: %s", version, err)
+		}
+		if !bytes.Equal(b, payload) {
+			t.Fatalf("unexpected v%d PDU body: %v", version, b)
+		}
+		if h := r.Header(); h.Version != version || h.Length != len(payload) {
+			t.Fatalf("unexpected v%d PDU header: %#v", version, h)
+		}
+	}
+
+	if _, err := r.ReadPDU(nil); err != io.EOF {
+		t.Fatalf("expected io.EOF after last PDU, found %v", err)
+	}
+}
+
+func TestSplitPDU(t *testing.T) {
+	var (
+		v1 = []byte("\x00\x01\x03\x02\x03\x2a")
+		v2 = []byte("\x00\x02\x00\x00\x00\x00\x03\x06\x02\x03\x03foo")
+	)
+
+	s := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(append(append([]byte(nil), v1...), v2...))))
+	s.Split(SplitPDU)
+
+	for _, expected := range [][]byte{v1, v2} {
+		if !s.Scan() {
+			t.Fatalf("unexpected end of scan: %v", s.Err())
+		}
+		if !bytes.Equal(s.Bytes(), expected) {
+			t.Fatalf("unexpected PDU:\n\nexpected = %v\n\nactual = %v", expected, s.Bytes())
+		}
+	}
+	if s.Scan() || s.Err() != nil {
+		t.Fatalf("expected clean end of scan, found %v", s.Err())
+	}
+
+	s = bufio.NewScanner(bytes.NewReader(v1[:5]))
+	s.Split(SplitPDU)
+	if s.Scan() || s.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, found %v", s.Err())
+	}
+
+	if _, _, err := SplitPDU([]byte("\x00\x03"), false); err == nil {
+		t.Fatal("unexpectedly no error for invalid prefix")
+	}
+}
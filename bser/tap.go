@@ -5,11 +5,51 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"reflect"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Direction is the way a PDU passed through a Tap
+type Direction int
+
+// the directions of a PDU
+const (
+	// Incoming PDUs were read from the underlying stream
+	Incoming Direction = iota
+	// Outgoing PDUs were written to it
+	Outgoing
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Incoming:
+		return "incoming"
+	case Outgoing:
+		return "outgoing"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// Record describes a PDU that passed through a Tap
+type Record struct {
+	// Seq numbers the PDUs of a Tap in both directions from 1,
+	// in the order they were completed
+	Seq uint64
+	// Time is when the PDU was completed
+	Time      time.Time
+	Direction Direction
+	// PDU is the whole PDU, including its header
+	PDU []byte
+}
+
+// Body returns the encoded value of the PDU, without its header
+func (r Record) Body() ([]byte, error) {
+	return NewPDUReader(bytes.NewReader(r.PDU)).ReadPDU(nil)
+}
+
 // NewTap sends all PDUs sent in either direction along rw
 // through rfn for reads and wfn for writes
 func NewTap(rw io.ReadWriter, rfn func([]byte), wfn func([]byte)) *Tap {
@@ -19,12 +59,27 @@ func NewTap(rw io.ReadWriter, rfn func([]byte), wfn func([]byte)) *Tap {
 	return t
 }
 
+// NewRecordingTap sends a Record of each PDU sent in either
+// direction along rw through fn. Unlike the functions of NewTap, fn is
+// called synchronously by the Read or Write that completes each PDU.
+// Outgoing PDUs are recorded before they are written to rw, so a
+// request is always recorded ahead of the response it is answered by.
+// fn must not block.
+func NewRecordingTap(rw io.ReadWriter, fn func(Record)) *Tap {
+	t := &Tap{rw: rw, recfn: fn}
+	t.Tap()
+
+	return t
+}
+
 // Tap is a io.ReadWriter that will pass data through some additional functions
 type Tap struct {
 	mu       sync.RWMutex
 	rw       io.ReadWriter
 	rfn      func([]byte)
 	wfn      func([]byte)
+	recfn    func(Record)
+	seq      uint64
 	r        io.Reader
 	w        io.Writer
 	tapped   bool
@@ -56,6 +111,12 @@ func (t *Tap) Tap() {
 		t.w = io.MultiWriter(t.rw, lw)
 		t.cleanups = append(t.cleanups, cl)
 	}
+	if t.recfn != nil {
+		t.r = io.TeeReader(t.r, &recorder{t: t, dir: Incoming})
+		// record before writing, as the response may otherwise
+		// be read and recorded first
+		t.w = io.MultiWriter(&recorder{t: t, dir: Outgoing}, t.w)
+	}
 }
 
 // Untap disables the functionality
@@ -98,8 +159,9 @@ func (t *Tap) Write(b []byte) (int, error) {
 func (t *Tap) logWriter(fn func([]byte)) (io.Writer, func()) {
 	pr, pw := io.Pipe()
 	go func() {
+		r := NewPDUReader(pr)
 		for {
-			buf, err := readPDU(pr)
+			buf, err := r.ReadPDU(nil)
 			if err != nil {
 				return
 			}
@@ -121,25 +183,34 @@ func (t *Tap) logWriter(fn func([]byte)) (io.Writer, func()) {
 	}
 }
 
-func readPDU(r io.Reader) ([]byte, error) {
-	buf := make([]byte, 2)
-	if _, err := r.Read(buf); err != nil {
-		return nil, err
-	}
+// recorder splits the data passing through a Tap in one direction
+// into PDUs and records each of them as it completes
+type recorder struct {
+	t   *Tap
+	dir Direction
+	buf []byte
+}
 
-	if !bytes.Equal(buf, protocolPrefix) {
-		return nil, fmt.Errorf("Expected %x, found %x", protocolPrefix, buf)
-	}
+func (r *recorder) Write(b []byte) (int, error) {
+	r.buf = append(r.buf, b...)
+	for {
+		n, pdu, err := SplitPDU(r.buf, false)
+		if err != nil {
+			// the stream can't be followed any further
+			r.buf = nil
+			return len(b), nil
+		}
+		if pdu == nil {
+			return len(b), nil
+		}
 
-	var size int
-	if err := decodeValue(r, reflect.ValueOf(&size), nil); err != nil {
-		return nil, err
-	}
+		r.t.recfn(Record{
+			Seq:       atomic.AddUint64(&r.t.seq, 1),
+			Time:      time.Now(),
+			Direction: r.dir,
+			PDU:       append([]byte(nil), pdu...),
+		})
 
-	buf = make([]byte, size)
-	if _, err := r.Read(buf); err != nil {
-		return nil, err
+		r.buf = r.buf[:copy(r.buf, r.buf[n:])]
 	}
-
-	return buf, nil
 }
@@ -0,0 +1,283 @@
+#!/usr/bin/env python3
+"""Regenerates the BSER conformance corpus from the reference implementations.
+
+The pywatchman cases are encoded with pywatchman. The watchman cases are
+the PDUs a running watchman server sends over its socket, captured
+byte for byte. Each case's JSON is written from the value as Python
+decodes it, independently of the Go package under test. corpus.json
+records the version of the implementation that produced each case.
+
+usage: generate.py [--pywatchman] [--server]
+
+With neither flag, both kinds of case are regenerated. The server cases
+need `watchman` on PATH and pywatchman to frame requests.
+"""
+
+import argparse
+import importlib.metadata
+import json
+import os
+import socket
+import subprocess
+import sys
+import tempfile
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+try:
+    from pywatchman import bser
+
+    BSER_MODULE = "bser"
+except ImportError:
+    from pywatchman import pybser as bser
+
+    BSER_MODULE = "pybser"
+
+
+# the corpus.json entry of each case, less the version that generated it
+CASES = {
+    "py_v1_scalars": ("pywatchman", "booleans, null, reals and strings", True),
+    "py_v1_ints": ("pywatchman", "every integer width at and either side of its limits", True),
+    "py_v1_nested": ("pywatchman", "nested objects and arrays, including empty ones", True),
+    "py_v1_command": ("pywatchman", "a query command as pywatchman sends it", True),
+    "py_v2_strings": (
+        "pywatchman",
+        "v2 UTF-8 strings and keys alongside a byte string that is not valid UTF-8",
+        False,
+    ),
+    "server_v1_query": (
+        "watchman",
+        "a query response whose files are a templated array with missing values",
+        False,
+    ),
+    "server_v1_empty_template": ("watchman", "a templated array with no rows", False),
+    "server_v1_error": ("watchman", "an error response", False),
+    "server_v1_log": ("watchman", "a unilateral log event", False),
+    "server_v2_subscription": (
+        "watchman",
+        "a v2 subscription event with UTF-8 strings, a byte string file name and missing values",
+        False,
+    ),
+}
+
+# the values of the pywatchman cases and the BSER version they are encoded with
+PYWATCHMAN_CASES = {
+    "py_v1_scalars": (1, [True, False, None, 1.5, -0.25, 0.0, 1e300, "hello", ""]),
+    "py_v1_ints": (
+        1,
+        [
+            0,
+            127,
+            -128,
+            128,
+            -129,
+            32767,
+            -32768,
+            32768,
+            -32769,
+            2147483647,
+            -2147483648,
+            2147483648,
+            -2147483649,
+            9223372036854775807,
+            -9223372036854775808,
+        ],
+    ),
+    "py_v1_nested": (1, {"a": {"b": [1, {"c": None}]}, "d": [], "e": {}}),
+    "py_v1_command": (
+        1,
+        [
+            "query",
+            "/tmp/root",
+            {
+                "expression": ["allof", ["type", "f"], ["suffix", "go"]],
+                "fields": ["name", "size", "mtime_ms"],
+            },
+        ],
+    ),
+    "py_v2_strings": (2, {"name": "héllo 世界", "raw": b"\xff\xfe", "empty": ""}),
+}
+
+
+def jsonable(v):
+    """Returns v with byte strings decoded as Go's AppendJSON decodes them"""
+    if isinstance(v, bytes):
+        return v.decode("utf-8", errors="replace")
+    if isinstance(v, (list, tuple)):
+        return [jsonable(x) for x in v]
+    if isinstance(v, dict):
+        return {jsonable(k): jsonable(x) for k, x in v.items()}
+    return v
+
+
+def write_case(name, pdu, value):
+    with open(os.path.join(HERE, name + ".bser"), "wb") as f:
+        f.write(pdu)
+    with open(os.path.join(HERE, name + ".json"), "w", encoding="utf-8") as f:
+        json.dump(jsonable(value), f, indent=2, ensure_ascii=False)
+        f.write("\n")
+
+
+def generate_pywatchman():
+    version = "pywatchman %s (%s)" % (importlib.metadata.version("pywatchman"), BSER_MODULE)
+    for name, (bser_version, value) in PYWATCHMAN_CASES.items():
+        write_case(name, bser.dumps(value, version=bser_version), value)
+    return {name: version for name in PYWATCHMAN_CASES}
+
+
+def read_exact(sock, n):
+    buf = b""
+    while len(buf) < n:
+        chunk = sock.recv(n - len(buf))
+        if not chunk:
+            raise EOFError("watchman closed the connection")
+        buf += chunk
+    return buf
+
+
+INT_SIZES = {0x03: 1, 0x04: 2, 0x05: 4, 0x06: 8}
+
+
+def read_pdu(sock):
+    """Reads a whole PDU, header included, exactly as the server sent it"""
+    header = read_exact(sock, 2)
+    if header == b"\x00\x02":
+        header += read_exact(sock, 4)  # capabilities
+    elif header != b"\x00\x01":
+        raise ValueError("unexpected PDU magic %r" % header)
+
+    marker = read_exact(sock, 1)
+    size = read_exact(sock, INT_SIZES[marker[0]])
+    length = int.from_bytes(size, "little", signed=True)
+
+    return header + marker + size + read_exact(sock, length)
+
+
+def loads(pdu):
+    return bser.loads(pdu, True, value_encoding="utf-8", value_errors="replace")
+
+
+def request(sock, args, bser_version=1):
+    sock.sendall(bser.dumps(args, version=bser_version))
+    while True:
+        pdu = read_pdu(sock)
+        value = loads(pdu)
+        if not value.get("unilateral"):
+            return pdu, value
+
+
+def read_unilateral(sock, key):
+    while True:
+        pdu = read_pdu(sock)
+        value = loads(pdu)
+        if value.get("unilateral") and key in value:
+            return pdu, value
+
+
+def generate_server():
+    sockname = json.loads(subprocess.check_output(["watchman", "get-sockname"]))["sockname"]
+    sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+    sock.connect(sockname)
+
+    _, version = request(sock, ["version"])
+    version = "watchman %s" % version["version"]
+
+    root = os.path.realpath(tempfile.mkdtemp(prefix="bser-conformance-"))
+    with open(os.path.join(root, ".watchmanconfig"), "w") as f:
+        f.write("{}")
+    with open(os.path.join(root, "a.go"), "w") as f:
+        f.write("package a\n")
+    os.mkdir(os.path.join(root, "c"))
+    with open(os.path.join(root, "c", "d.go"), "w") as f:
+        f.write("package c\n")
+    os.symlink("a.go", os.path.join(root, "link.go"))
+
+    _, watch = request(sock, ["watch-project", root])
+    if "error" in watch:
+        raise RuntimeError(watch["error"])
+
+    fields = ["name", "exists", "type", "symlink_target"]
+    cases = {}
+
+    # symlink_target is missing for the files that aren't symlinks
+    pdu, value = request(
+        sock, ["query", root, {"expression": ["suffix", "go"], "fields": fields}]
+    )
+    write_case("server_v1_query", pdu, value)
+    cases["server_v1_query"] = version
+
+    pdu, value = request(
+        sock, ["query", root, {"expression": ["name", "nothing"], "fields": ["name"]}]
+    )
+    if b"\x0b" not in pdu:
+        print("warning: server_v1_empty_template has no templated array", file=sys.stderr)
+    write_case("server_v1_empty_template", pdu, value)
+    cases["server_v1_empty_template"] = version
+
+    pdu, value = request(sock, ["query", os.path.join(root, "nope"), {}])
+    write_case("server_v1_error", pdu, value)
+    cases["server_v1_error"] = version
+
+    request(sock, ["log-level", "debug"])
+    sock.sendall(bser.dumps(["log", "debug", "hello"], version=1))
+    pdu, value = read_unilateral(sock, "log")
+    write_case("server_v1_log", pdu, value)
+    cases["server_v1_log"] = version
+    request(sock, ["log-level", "off"])
+
+    # a v2 connection is sent strings as UTF-8 and names that aren't valid
+    # UTF-8 as byte strings
+    sock2 = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+    sock2.connect(sockname)
+    request(sock2, ["subscribe", root, "sub1", {"fields": fields}], bser_version=2)
+    read_unilateral(sock2, "subscription")  # the initial file list
+
+    with open(os.path.join(root.encode(), b"\xc3(bad"), "w") as f:
+        f.write("bad\n")
+    pdu, value = read_unilateral(sock2, "subscription")
+    write_case("server_v2_subscription", pdu, value)
+    cases["server_v2_subscription"] = version
+
+    sock2.close()
+    request(sock, ["watch-del", root])
+    sock.close()
+
+    return cases
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.split("\n")[0])
+    parser.add_argument("--pywatchman", action="store_true", help="regenerate the pywatchman cases")
+    parser.add_argument("--server", action="store_true", help="regenerate the watchman cases")
+    args = parser.parse_args()
+    if not args.pywatchman and not args.server:
+        args.pywatchman = args.server = True
+
+    versions = {}
+    if args.pywatchman:
+        versions.update(generate_pywatchman())
+    if args.server:
+        versions.update(generate_server())
+
+    # cases of the kind not regenerated are kept as they were
+    path = os.path.join(HERE, "corpus.json")
+    with open(path) as f:
+        corpus = {case["name"]: case for case in json.load(f)}
+    for name, version in versions.items():
+        source, description, canonical = CASES[name]
+        corpus[name] = {
+            "name": name,
+            "source": source,
+            "description": description,
+            "canonical": canonical,
+            "generated_by": version,
+        }
+    corpus = [corpus[name] for name in CASES if name in corpus]
+
+    with open(path, "w") as f:
+        json.dump(corpus, f, indent=2)
+        f.write("\n")
+
+
+if __name__ == "__main__":
+    main()
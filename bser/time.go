@@ -0,0 +1,231 @@
+package bser
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	typTime     = reflect.TypeOf(time.Time{})
+	typDuration = reflect.TypeOf(time.Duration(0))
+)
+
+// units are the tag options selecting the unit of time.Time
+// and time.Duration fields
+var units = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+// tagUnit returns the unit selected by the options of a field tag, or 0
+func tagUnit(opts tagOptions) time.Duration {
+	for _, opt := range strings.Split(string(opts), ",") {
+		if unit, ok := units[opt]; ok {
+			return unit
+		}
+	}
+
+	return 0
+}
+
+// timeUnit returns unit, defaulting to seconds as watchman uses for
+// timestamps such as mtime
+func timeUnit(unit time.Duration) time.Duration {
+	if unit <= 0 {
+		return time.Second
+	}
+	return unit
+}
+
+// durationUnit returns unit, defaulting to nanoseconds as
+// time.Duration itself does
+func durationUnit(unit time.Duration) time.Duration {
+	if unit <= 0 {
+		return time.Nanosecond
+	}
+	return unit
+}
+
+// AppendTime appends t as an integer count of unit since the Unix epoch,
+// truncating any remainder. A unit of 0 means seconds. The zero time is
+// appended as null.
+func AppendTime(buf []byte, t time.Time, unit time.Duration) []byte {
+	if t.IsZero() {
+		return AppendNull(buf)
+	}
+
+	return AppendInt64(buf, timeToUnits(t, timeUnit(unit)))
+}
+
+// AppendDuration appends v as an integer count of unit, truncating any
+// remainder. A unit of 0 means nanoseconds.
+func AppendDuration(buf []byte, v time.Duration, unit time.Duration) []byte {
+	return AppendInt64(buf, int64(v/durationUnit(unit)))
+}
+
+// ReadTime reads an integer or real count of unit since the Unix epoch.
+// A unit of 0 means seconds. Null reads as the zero time.
+func (c *Cursor) ReadTime(unit time.Duration) (time.Time, error) {
+	var t time.Time
+	err := c.d.timeValue(reflect.ValueOf(&t).Elem(), unit)
+	return t, err
+}
+
+// ReadDuration reads an integer or real count of unit. A unit of 0 means
+// nanoseconds.
+func (c *Cursor) ReadDuration(unit time.Duration) (time.Duration, error) {
+	var v time.Duration
+	err := c.d.timeValue(reflect.ValueOf(&v).Elem(), unit)
+	return v, err
+}
+
+// timeEncoder returns the encoderFunc for the time.Time or time.Duration
+// type t counted in unit, or in the type's default unit if unit is 0
+func timeEncoder(t reflect.Type, unit time.Duration) encoderFunc {
+	if t == typDuration {
+		return func(e *encodeState, v reflect.Value) error {
+			e.buf = AppendDuration(e.buf, time.Duration(v.Int()), unit)
+			return nil
+		}
+	}
+
+	return func(e *encodeState, v reflect.Value) error {
+		e.buf = AppendTime(e.buf, v.Interface().(time.Time), unit)
+		return nil
+	}
+}
+
+// isTime reports whether t is time.Time or time.Duration,
+// or a pointer to one
+func isTime(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t == typTime || t == typDuration
+}
+
+// unitEncoder returns the encoderFunc for t, for which isTime is
+// true, counted in unit. Nil pointers are encoded as null.
+func unitEncoder(t reflect.Type, unit time.Duration) encoderFunc {
+	if t.Kind() != reflect.Ptr {
+		return timeEncoder(t, unit)
+	}
+
+	elemEnc := unitEncoder(t.Elem(), unit)
+	return func(e *encodeState, v reflect.Value) error {
+		if v.IsNil() {
+			e.buf = append(e.buf, 0x0a)
+			return nil
+		}
+
+		return elemEnc(e, v.Elem())
+	}
+}
+
+// unitValue decodes a count of unit into dest, a pointer to a type for
+// which isTime is true, allocating any pointers along the way
+func (d *decodeState) unitValue(dest reflect.Value, unit time.Duration) error {
+	for dest.Elem().Kind() == reflect.Ptr {
+		// null and missing values are left to value, which clears
+		// or skips them
+		if m, _ := d.peek(); m == 0x0a || m == 0x0c {
+			return d.value(dest)
+		}
+
+		if dest.Elem().IsNil() {
+			dest.Elem().Set(reflect.New(dest.Elem().Type().Elem()))
+		}
+		dest = dest.Elem()
+	}
+
+	return d.timeValue(dest.Elem(), unit)
+}
+
+// timeValue decodes an integer or real count of unit into the
+// time.Time or time.Duration v
+func (d *decodeState) timeValue(v reflect.Value, unit time.Duration) error {
+	if m, err := d.peek(); err == nil && m == 0x0c {
+		d.off++
+		return nil
+	}
+
+	off := d.off
+	m, err := d.marker()
+	if err != nil {
+		return err
+	}
+	d.m = m
+
+	var (
+		n      int64
+		f      float64
+		isReal bool
+	)
+
+	switch m {
+	case 0x03, 0x04, 0x05, 0x06:
+		if n, err = d.int(m); err != nil {
+			return err
+		}
+	case 0x07:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		f, isReal = math.Float64frombits(order.Uint64(b)), true
+	case 0x0a:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	default:
+		return d.typeError(off, v.Type())
+	}
+
+	if v.Type() == typDuration {
+		unit = durationUnit(unit)
+		if isReal {
+			v.SetInt(int64(f * float64(unit)))
+		} else {
+			v.SetInt(n * int64(unit))
+		}
+		return nil
+	}
+
+	unit = timeUnit(unit)
+	if isReal {
+		sec, frac := math.Modf(f * float64(unit) / float64(time.Second))
+		v.Set(reflect.ValueOf(time.Unix(int64(sec), int64(math.Round(frac*float64(time.Second))))))
+	} else {
+		v.Set(reflect.ValueOf(unitsToTime(n, unit)))
+	}
+	return nil
+}
+
+// timeToUnits returns the number of whole units between the Unix epoch and t
+func timeToUnits(t time.Time, unit time.Duration) int64 {
+	switch {
+	case unit%time.Second == 0:
+		return t.Unix() / int64(unit/time.Second)
+	case time.Second%unit == 0:
+		return t.Unix()*int64(time.Second/unit) + int64(t.Nanosecond())/int64(unit)
+	default:
+		return t.UnixNano() / int64(unit)
+	}
+}
+
+// unitsToTime returns the time n units after the Unix epoch
+func unitsToTime(n int64, unit time.Duration) time.Time {
+	switch {
+	case unit%time.Second == 0:
+		return time.Unix(n*int64(unit/time.Second), 0)
+	case time.Second%unit == 0:
+		per := int64(time.Second / unit)
+		return time.Unix(n/per, n%per*int64(unit))
+	default:
+		return time.Unix(0, n*int64(unit))
+	}
+}
@@ -0,0 +1,337 @@
+package bser
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type timedFile struct {
+	Name    string        `bser:"name"`
+	Mtime   time.Time     `bser:"mtime"`
+	MtimeMs time.Time     `bser:"mtime_ms,ms"`
+	MtimeNs time.Time     `bser:"mtime_ns,ns"`
+	Settle  time.Duration `bser:"settle,ms"`
+	Age     time.Duration `bser:"age"`
+}
+
+// timedPtrFile has pointers to the fields of timedFile
+type timedPtrFile struct {
+	Mtime   *time.Time     `bser:"mtime"`
+	MtimeMs *time.Time     `bser:"mtime_ms,ms"`
+	Settle  *time.Duration `bser:"settle,ms"`
+}
+
+// level is a custom string type encoded through encoding.TextMarshaler
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case 0:
+		return []byte("off"), nil
+	case 1:
+		return []byte("debug"), nil
+	default:
+		return nil, fmt.Errorf("invalid level %d", int(l))
+	}
+}
+
+func (l *level) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "off":
+		*l = 0
+	case "debug":
+		*l = 1
+	default:
+		return fmt.Errorf("invalid level %q", b)
+	}
+	return nil
+}
+
+func TestTimeEncode(t *testing.T) {
+	var (
+		mtime = time.Unix(1500000000, 123456789)
+		f     = timedFile{
+			Name:    "a",
+			Mtime:   mtime,
+			MtimeMs: mtime,
+			MtimeNs: mtime,
+			Settle:  20 * time.Millisecond,
+			Age:     time.Microsecond,
+		}
+	)
+
+	b, err := MarshalValue(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := AppendObjectHeader(nil, 6)
+	expected = AppendString(AppendString(expected, "name"), "a")
+	expected = AppendInt64(AppendString(expected, "mtime"), 1500000000)
+	expected = AppendInt64(AppendString(expected, "mtime_ms"), 1500000000123)
+	expected = AppendInt64(AppendString(expected, "mtime_ns"), 1500000000123456789)
+	expected = AppendInt64(AppendString(expected, "settle"), 20)
+	expected = AppendInt64(AppendString(expected, "age"), 1000)
+
+	if !bytes.Equal(expected, b) {
+		t.Fatalf("unexpected encoding:\n\nexpected = %v\n\nactual = %v", expected, b)
+	}
+
+	var actual timedFile
+	if err := UnmarshalValue(b, &actual); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f.Mtime = time.Unix(1500000000, 0)
+	f.MtimeMs = time.Unix(1500000000, 123000000)
+	if !reflect.DeepEqual(f, actual) {
+		t.Fatalf("unexpected round trip:\n\nexpected = %#v\n\nactual = %#v", f, actual)
+	}
+
+	// the zero time is encoded as null and decoded back to zero
+	if b, err = MarshalValue(timedFile{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Contains(b, append(AppendString(nil, "mtime"), 0x0a)) {
+		t.Fatalf("expected null mtime in %v", b)
+	}
+
+	actual = timedFile{Mtime: mtime}
+	if err := UnmarshalValue(b, &actual); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !actual.Mtime.IsZero() {
+		t.Fatalf("expected zero mtime, found %s", actual.Mtime)
+	}
+}
+
+var timeDecodeTests = map[string]struct {
+	encoded  []byte
+	dest     func() interface{}
+	expected interface{}
+	err      string
+}{
+	"time_seconds": {
+		encoded:  AppendInt32(nil, 1500000000),
+		dest:     func() interface{} { return new(time.Time) },
+		expected: time.Unix(1500000000, 0),
+	},
+	"time_real_seconds": {
+		encoded:  AppendReal(nil, 1500000000.5),
+		dest:     func() interface{} { return new(time.Time) },
+		expected: time.Unix(1500000000, 500000000),
+	},
+	"time_null": {
+		encoded:  AppendNull(nil),
+		dest:     func() interface{} { t := time.Unix(1, 0); return &t },
+		expected: time.Time{},
+	},
+	"time_string": {
+		encoded: AppendString(nil, "2017-07-14T02:40:00Z"),
+		dest:    func() interface{} { return new(time.Time) },
+		err:     "can't decode string to time.Time at offset 0",
+	},
+	"duration": {
+		encoded:  AppendInt(nil, 5),
+		dest:     func() interface{} { return new(time.Duration) },
+		expected: 5 * time.Nanosecond,
+	},
+	"duration_real": {
+		encoded:  AppendReal(nil, 1.5),
+		dest:     func() interface{} { return new(time.Duration) },
+		expected: time.Duration(1),
+	},
+	"time_map_elem": {
+		encoded: append(append(AppendObjectHeader(nil, 1), AppendString(nil, "t")...), AppendInt(nil, 1)...),
+		dest:    func() interface{} { return new(map[string]time.Time) },
+		expected: map[string]time.Time{
+			"t": time.Unix(1, 0),
+		},
+	},
+	"ms_field_real": {
+		encoded: append(append(AppendObjectHeader(nil, 1), AppendString(nil, "mtime_ms")...), AppendReal(nil, 1500.25)...),
+		dest:    func() interface{} { return new(timedFile) },
+		expected: timedFile{
+			MtimeMs: time.Unix(1, 500250000),
+		},
+	},
+	"ms_field_template": {
+		encoded: append(AppendTemplateHeader(nil, []string{"mtime_ms", "settle"}, 2),
+			0x03, 0x01, 0x0c,
+			0x0c, 0x03, 0x02,
+		),
+		dest: func() interface{} { return new([]timedFile) },
+		expected: []timedFile{
+			{MtimeMs: time.Unix(0, 1000000)},
+			{Settle: 2 * time.Millisecond},
+		},
+	},
+	"ms_ptr_field": {
+		encoded: append(append(AppendObjectHeader(nil, 1), AppendString(nil, "mtime_ms")...), AppendInt64(nil, 1518000000123)...),
+		dest:    func() interface{} { return new(timedPtrFile) },
+		expected: timedPtrFile{
+			MtimeMs: timePtr(time.Unix(1518000000, 123000000)),
+		},
+	},
+	"ms_ptr_field_null": {
+		encoded:  append(append(AppendObjectHeader(nil, 1), AppendString(nil, "mtime_ms")...), AppendNull(nil)...),
+		dest:     func() interface{} { return &timedPtrFile{MtimeMs: timePtr(time.Unix(1, 0))} },
+		expected: timedPtrFile{},
+	},
+	"ms_ptr_field_template": {
+		encoded: append(AppendTemplateHeader(nil, []string{"mtime_ms", "settle"}, 2),
+			0x03, 0x01, 0x0c,
+			0x0c, 0x03, 0x02,
+		),
+		dest: func() interface{} { return new([]timedPtrFile) },
+		expected: []timedPtrFile{
+			{MtimeMs: timePtr(time.Unix(0, 1000000))},
+			{Settle: durationPtr(2 * time.Millisecond)},
+		},
+	},
+	"ms_field_bool": {
+		encoded: append(append(AppendObjectHeader(nil, 1), AppendString(nil, "settle")...), 0x08),
+		dest:    func() interface{} { return new(timedFile) },
+		err:     "can't decode bool to time.Duration at offset 12 in field settle",
+	},
+	"text": {
+		encoded:  AppendString(nil, "debug"),
+		dest:     func() interface{} { return new(level) },
+		expected: level(1),
+	},
+	"text_utf8": {
+		encoded:  []byte("\x0d\x03\x05debug"),
+		dest:     func() interface{} { return new(level) },
+		expected: level(1),
+	},
+	"text_int": {
+		encoded:  AppendInt(nil, 1),
+		dest:     func() interface{} { return new(level) },
+		expected: level(1),
+	},
+	"text_error": {
+		encoded: AppendString(nil, "loud"),
+		dest:    func() interface{} { return new(level) },
+		err:     `invalid level "loud"`,
+	},
+	"text_map": {
+		encoded: append(append(AppendObjectHeader(nil, 1), AppendString(nil, "l")...), AppendString(nil, "off")...),
+		dest:    func() interface{} { return &map[string]level{"l": 1} },
+		expected: map[string]level{
+			"l": 0,
+		},
+	},
+}
+
+func timePtr(t time.Time) *time.Time             { return &t }
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+func TestTimePtrEncode(t *testing.T) {
+	var (
+		mtime = time.Unix(1518000000, 123456789)
+		f     = timedPtrFile{
+			Mtime:   &mtime,
+			MtimeMs: &mtime,
+			Settle:  durationPtr(20 * time.Millisecond),
+		}
+	)
+
+	b, err := MarshalValue(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := AppendObjectHeader(nil, 3)
+	expected = AppendInt64(AppendString(expected, "mtime"), 1518000000)
+	expected = AppendInt64(AppendString(expected, "mtime_ms"), 1518000000123)
+	expected = AppendInt64(AppendString(expected, "settle"), 20)
+
+	if !bytes.Equal(expected, b) {
+		t.Fatalf("unexpected encoding:\n\nexpected = %v\n\nactual = %v", expected, b)
+	}
+
+	var actual timedPtrFile
+	if err := UnmarshalValue(b, &actual); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f.Mtime = timePtr(time.Unix(1518000000, 0))
+	f.MtimeMs = timePtr(time.Unix(1518000000, 123000000))
+	if !reflect.DeepEqual(f, actual) {
+		t.Fatalf("unexpected round trip:\n\nexpected = %#v\n\nactual = %#v", f, actual)
+	}
+
+	// nil pointers are encoded as null
+	if b, err = MarshalValue(timedPtrFile{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected = AppendObjectHeader(nil, 3)
+	expected = AppendNull(AppendString(expected, "mtime"))
+	expected = AppendNull(AppendString(expected, "mtime_ms"))
+	expected = AppendNull(AppendString(expected, "settle"))
+
+	if !bytes.Equal(expected, b) {
+		t.Fatalf("unexpected encoding:\n\nexpected = %v\n\nactual = %v", expected, b)
+	}
+}
+
+func TestTimeDecode(t *testing.T) {
+	for testName, testCase := range timeDecodeTests {
+		t.Run(testName, func(t *testing.T) {
+			dest := testCase.dest()
+			err := UnmarshalValue(testCase.encoded, dest)
+			if testCase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.err) {
+					t.Fatalf("expected error %q, found %v", testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if actual := reflect.ValueOf(dest).Elem().Interface(); !reflect.DeepEqual(testCase.expected, actual) {
+				t.Fatalf("unexpected decoded value:\n\nexpected = %#v\n\nactual = %#v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTextMarshalerEncode(t *testing.T) {
+	b, err := MarshalValue(map[string]level{"l": 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := append(append(AppendObjectHeader(nil, 1), AppendString(nil, "l")...), AppendString(nil, "debug")...)
+	if !bytes.Equal(expected, b) {
+		t.Fatalf("unexpected encoding:\n\nexpected = %v\n\nactual = %v", expected, b)
+	}
+
+	if _, err := MarshalValue(level(7)); err == nil {
+		t.Fatal("unexpectedly no error")
+	}
+}
+
+func TestCursorTime(t *testing.T) {
+	var buf []byte
+	buf = AppendTime(buf, time.Unix(2, 5000000), time.Millisecond)
+	buf = AppendTime(buf, time.Time{}, 0)
+	buf = AppendDuration(buf, 3*time.Second, time.Second)
+
+	c := NewCursor(buf)
+	if v, err := c.ReadTime(time.Millisecond); err != nil || !v.Equal(time.Unix(2, 5000000)) {
+		t.Fatalf("unexpected time %s, %v", v, err)
+	}
+	if v, err := c.ReadTime(0); err != nil || !v.IsZero() {
+		t.Fatalf("unexpected time %s, %v", v, err)
+	}
+	if v, err := c.ReadDuration(time.Second); err != nil || v != 3*time.Second {
+		t.Fatalf("unexpected duration %s, %v", v, err)
+	}
+}
@@ -0,0 +1,295 @@
+package bser
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errMissingValue = errors.New("bser.Value: missing value")
+
+// Value is a read only view of an encoded BSER value. Values are
+// decoded lazily: navigating with Get and Index only walks the bytes
+// needed to find the requested element and allocates nothing for the
+// values it skips. The zero Value is missing.
+type Value struct {
+	data  []byte   // the encoded value, or the values of a templated array row
+	names []string // the field names of a templated array row
+	err   error
+}
+
+// NewValue returns a Value reading the single encoded value b
+func NewValue(b []byte) Value {
+	return Value{data: b}
+}
+
+// Value returns a Value reading r
+func (r RawMessage) Value() Value {
+	return NewValue(r)
+}
+
+// Err returns the error, if any, encountered while navigating to v
+func (v Value) Err() error {
+	return v.err
+}
+
+// Exists reports whether v holds a value. It is false for keys and
+// indexes that were not found and values missing from a templated array.
+func (v Value) Exists() bool {
+	if v.err != nil {
+		return false
+	}
+
+	return v.names != nil || (len(v.data) > 0 && Type(v.data[0]) != TypeMissing)
+}
+
+// Kind returns the type of v. Templated array rows report TypeObject,
+// and values that do not exist report TypeMissing.
+func (v Value) Kind() Type {
+	switch {
+	case !v.Exists():
+		return TypeMissing
+	case v.names != nil:
+		return TypeObject
+	default:
+		return Type(v.data[0])
+	}
+}
+
+// Len returns the number of elements of an array or templated array,
+// the number of fields of an object or the length in bytes of a string.
+// It returns 0 for other values.
+func (v Value) Len() int {
+	if v.names != nil {
+		return len(v.names)
+	}
+
+	switch v.Kind() {
+	case TypeArray, TypeObject, TypeString, TypeUTF8String:
+		d := decodeState{data: v.data, off: 1}
+		n, _ := d.length()
+		return n
+	case TypeTemplate:
+		c := NewCursor(v.data)
+		_, n, _ := c.ReadTemplateHeader()
+		return n
+	default:
+		return 0
+	}
+}
+
+// Get returns the value of the object field key. The result is missing
+// if v has no such field.
+func (v Value) Get(key string) Value {
+	if v.names != nil {
+		return v.rowField(key)
+	}
+
+	switch k := v.Kind(); k {
+	case TypeObject:
+	case TypeMissing:
+		return v
+	default:
+		return Value{err: fmt.Errorf("bser.Value: Get(%q) on %s", key, k)}
+	}
+
+	d := decodeState{data: v.data, off: 1}
+	n, err := d.length()
+	if err != nil {
+		return Value{err: err}
+	}
+
+	for i := 0; i < n; i++ {
+		name, err := d.key()
+		if err != nil {
+			return Value{err: err}
+		}
+
+		if string(name) == key {
+			return v.next(&d)
+		}
+
+		if err := d.value(emptyValue); err != nil {
+			return Value{err: err}
+		}
+	}
+
+	return Value{}
+}
+
+// Index returns the i'th element of an array or templated array. The
+// result is missing if i is out of range.
+func (v Value) Index(i int) Value {
+	switch k := v.Kind(); k {
+	case TypeArray:
+		d := decodeState{data: v.data, off: 1}
+		n, err := d.length()
+		if err != nil {
+			return Value{err: err}
+		}
+
+		if i < 0 || i >= n {
+			return Value{}
+		}
+
+		return v.nth(&d, i, 1)
+	case TypeTemplate:
+		c := NewCursor(v.data)
+		names, n, err := c.ReadTemplateHeader()
+		if err != nil {
+			return Value{err: err}
+		}
+
+		if i < 0 || i >= n {
+			return Value{}
+		}
+
+		row := v.nth(&c.d, i, len(names))
+		if row.err == nil {
+			row.names = names
+		}
+		return row
+	case TypeMissing:
+		return v
+	default:
+		return Value{err: fmt.Errorf("bser.Value: Index(%d) on %s", i, k)}
+	}
+}
+
+// AsString returns the value of a string, or "" for null
+func (v Value) AsString() (string, error) {
+	if err := v.check(); err != nil {
+		return "", err
+	}
+
+	return NewCursor(v.data).ReadString()
+}
+
+// AsInt returns the value of an integer of any width, or 0 for null
+func (v Value) AsInt() (int64, error) {
+	if err := v.check(); err != nil {
+		return 0, err
+	}
+
+	return NewCursor(v.data).ReadInt()
+}
+
+// AsReal returns the value of a real, or 0 for null
+func (v Value) AsReal() (float64, error) {
+	if err := v.check(); err != nil {
+		return 0, err
+	}
+
+	return NewCursor(v.data).ReadReal()
+}
+
+// AsBool returns the value of a boolean, or false for null
+func (v Value) AsBool() (bool, error) {
+	if err := v.check(); err != nil {
+		return false, err
+	}
+
+	return NewCursor(v.data).ReadBool()
+}
+
+// Raw returns the encoded value. It aliases the underlying data except
+// for templated array rows, which are encoded as objects.
+func (v Value) Raw() (RawMessage, error) {
+	if err := v.check(); err != nil {
+		return nil, err
+	}
+
+	if v.names == nil {
+		return RawMessage(v.data), nil
+	}
+
+	var (
+		d      = decodeState{data: v.data}
+		fields []byte
+		n      int
+	)
+
+	for _, name := range v.names {
+		if m, _ := d.peek(); m == 0x0c {
+			d.off++
+			continue
+		}
+
+		start := d.off
+		if err := d.value(emptyValue); err != nil {
+			return nil, err
+		}
+
+		fields = appendString(fields, name)
+		fields = append(fields, v.data[start:d.off]...)
+		n++
+	}
+
+	return append(AppendObjectHeader(nil, n), fields...), nil
+}
+
+// Decode decodes v into dest as UnmarshalValue does
+func (v Value) Decode(dest interface{}) error {
+	b, err := v.Raw()
+	if err != nil {
+		return err
+	}
+
+	return UnmarshalValue(b, dest)
+}
+
+func (v Value) check() error {
+	if v.err != nil {
+		return v.err
+	}
+
+	if !v.Exists() {
+		return errMissingValue
+	}
+
+	return nil
+}
+
+// next returns the value at the current offset of d and skips past it
+func (v Value) next(d *decodeState) Value {
+	start := d.off
+	if err := d.value(emptyValue); err != nil {
+		return Value{err: err}
+	}
+
+	return Value{data: v.data[start:d.off]}
+}
+
+// nth skips to element i of the elements of width values each
+// that follow the current offset of d, and returns its values
+func (v Value) nth(d *decodeState, i, width int) Value {
+	for j := 0; j < i*width; j++ {
+		if err := d.value(emptyValue); err != nil {
+			return Value{err: err}
+		}
+	}
+
+	start := d.off
+	for j := 0; j < width; j++ {
+		if err := d.value(emptyValue); err != nil {
+			return Value{err: err}
+		}
+	}
+
+	return Value{data: v.data[start:d.off]}
+}
+
+// rowField returns the value of field key of a templated array row
+func (v Value) rowField(key string) Value {
+	d := decodeState{data: v.data}
+	for _, name := range v.names {
+		if name == key {
+			return v.next(&d)
+		}
+
+		if err := d.value(emptyValue); err != nil {
+			return Value{err: err}
+		}
+	}
+
+	return Value{}
+}
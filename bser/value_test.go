@@ -0,0 +1,192 @@
+package bser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	type file struct {
+		Name string `bser:"name"`
+		Size int    `bser:"size"`
+	}
+
+	msg := RawMessage(mustMarshalValue(map[string]interface{}{
+		"clock":        "c:123",
+		"unilateral":   true,
+		"subscription": "sub",
+		"count":        100001,
+		"ratio":        0.5,
+		"files":        []file{{"a", 1}, {"b", 2}},
+		"tags":         []string{"x", "y", "z"},
+		"nothing":      nil,
+	}))
+
+	v := msg.Value()
+
+	var valueTests = map[string]struct {
+		value    Value
+		kind     Type
+		len      int
+		get      func(v Value) (interface{}, error)
+		expected interface{}
+	}{
+		"root": {
+			value: v,
+			kind:  TypeObject,
+			len:   8,
+		},
+		"string": {
+			value:    v.Get("clock"),
+			kind:     TypeString,
+			len:      5,
+			get:      func(v Value) (interface{}, error) { return v.AsString() },
+			expected: "c:123",
+		},
+		"bool": {
+			value:    v.Get("unilateral"),
+			kind:     TypeTrue,
+			get:      func(v Value) (interface{}, error) { return v.AsBool() },
+			expected: true,
+		},
+		"int": {
+			value:    v.Get("count"),
+			kind:     TypeInt32,
+			get:      func(v Value) (interface{}, error) { return v.AsInt() },
+			expected: int64(100001),
+		},
+		"real": {
+			value:    v.Get("ratio"),
+			kind:     TypeReal,
+			get:      func(v Value) (interface{}, error) { return v.AsReal() },
+			expected: 0.5,
+		},
+		"null": {
+			value:    v.Get("nothing"),
+			kind:     TypeNull,
+			get:      func(v Value) (interface{}, error) { return v.AsString() },
+			expected: "",
+		},
+		"array_index": {
+			value:    v.Get("tags").Index(2),
+			kind:     TypeString,
+			len:      1,
+			get:      func(v Value) (interface{}, error) { return v.AsString() },
+			expected: "z",
+		},
+		"template": {
+			value: v.Get("files"),
+			kind:  TypeTemplate,
+			len:   2,
+		},
+		"template_row": {
+			value: v.Get("files").Index(1),
+			kind:  TypeObject,
+			len:   2,
+			get: func(v Value) (interface{}, error) {
+				var f file
+				err := v.Decode(&f)
+				return f, err
+			},
+			expected: file{"b", 2},
+		},
+		"template_row_field": {
+			value:    v.Get("files").Index(1).Get("size"),
+			kind:     TypeInt8,
+			get:      func(v Value) (interface{}, error) { return v.AsInt() },
+			expected: int64(2),
+		},
+		"missing_key": {
+			value: v.Get("root"),
+			kind:  TypeMissing,
+		},
+		"out_of_range": {
+			value: v.Get("tags").Index(3),
+			kind:  TypeMissing,
+		},
+		"missing_chain": {
+			value: v.Get("root").Get("a").Index(0),
+			kind:  TypeMissing,
+		},
+	}
+
+	for testName, testCase := range valueTests {
+		t.Run(testName, func(t *testing.T) {
+			if err := testCase.value.Err(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if k := testCase.value.Kind(); k != testCase.kind {
+				t.Fatalf("unexpected kind: expected %s, found %s", testCase.kind, k)
+			}
+			if l := testCase.value.Len(); l != testCase.len {
+				t.Fatalf("unexpected len: expected %d, found %d", testCase.len, l)
+			}
+			if testCase.get == nil {
+				return
+			}
+
+			actual, err := testCase.get(testCase.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(actual, testCase.expected) {
+				t.Fatalf("unexpected value:\n\nexpected = %#v\n\nactual = %#v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestValueErrors(t *testing.T) {
+	v := NewValue(mustMarshalValue([]string{"a"}))
+
+	if err := v.Get("a").Err(); err == nil {
+		t.Error("expected error calling Get on an array")
+	}
+	if err := v.Index(0).Index(0).Err(); err == nil {
+		t.Error("expected error calling Index on a string")
+	}
+	if _, err := v.Index(0).AsInt(); err == nil {
+		t.Error("expected error reading a string as an int")
+	}
+	if _, err := v.Index(1).AsString(); err != errMissingValue {
+		t.Errorf("expected missing value error, found %v", err)
+	}
+}
+
+func TestValueGetAllocs(t *testing.T) {
+	type file struct {
+		Name string `bser:"name"`
+		Size int    `bser:"size"`
+	}
+
+	v := NewValue(mustMarshalValue(struct {
+		Files        []file `bser:"files"`
+		Subscription string `bser:"subscription"`
+	}{
+		Files:        []file{{"a", 1}, {"b", 2}, {"c", 3}},
+		Subscription: "sub",
+	}))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if !v.Get("subscription").Exists() {
+			t.Fatal("expected subscription to exist")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected Get to skip values without allocating, found %v allocs", allocs)
+	}
+}
+
+func TestValueUTF8String(t *testing.T) {
+	v := NewValue([]byte("\x0d\x03\x05hello"))
+
+	if k := v.Kind(); k != TypeUTF8String {
+		t.Fatalf("unexpected kind %s", k)
+	}
+	if l := v.Len(); l != 5 {
+		t.Fatalf("unexpected len %d", l)
+	}
+	if s, err := v.AsString(); err != nil || s != "hello" {
+		t.Fatalf("unexpected string %q, %v", s, err)
+	}
+}
@@ -0,0 +1,132 @@
+package watchman
+
+import "sync"
+
+// Call is a request made asynchronously with Go
+type Call struct {
+	// Args are the arguments sent to the server, command included
+	Args []interface{}
+	// Dest is the value the response is decoded into
+	Dest interface{}
+	// Response holds the fields common to every response,
+	// once the call is done
+	Response Response
+	// Err is the error of the call once it is done. Unlike Send, an
+	// error reported by watchman in the response is returned here.
+	Err error
+
+	done chan struct{}
+}
+
+// Done returns a channel that is closed once the call is done
+func (call *Call) Done() <-chan struct{} {
+	return call.done
+}
+
+// Wait waits for the call to be done and returns its error
+func (call *Call) Wait() error {
+	<-call.done
+	return call.Err
+}
+
+// Go makes a client call as Send does, without waiting for the response.
+// The request has been queued by the time Go returns, so requests are
+// written to the server in the order Go is called. Unless Pipeline is
+// above 1, each is still written only once the previous response has
+// arrived, so calls made with Go don't save round trips over Send.
+func (c *Client) Go(dest interface{}, args ...interface{}) *Call {
+	var (
+		call   = &Call{Args: args, Dest: dest, done: make(chan struct{})}
+		req    = newRequest(dest, args)
+		queued = make(chan struct{})
+		once   sync.Once
+	)
+
+	req.wantResponse = true
+	req.queued = func() {
+		once.Do(func() { close(queued) })
+	}
+
+	go func() {
+		defer close(call.done)
+
+		call.Err = c.do(req)
+		call.Response = req.Response
+		if call.Err == nil && req.Response.Error != "" {
+			call.Err = req.Response.Error
+		}
+	}()
+
+	// the call is done without being queued if it fails first, or an
+	// interceptor doesn't send it
+	select {
+	case <-queued:
+	case <-call.done:
+	}
+
+	return call
+}
+
+// Go makes a call on one of the pool's connections, as Client.Go does
+func (p *Pool) Go(dest interface{}, args ...interface{}) *Call {
+	return p.client(args).Go(dest, args...)
+}
+
+// NewDoneCall returns a Call of args that is already done, with the
+// error err. It lets implementations of API other than Client and Pool,
+// such as fakes, answer Go synchronously.
+func NewDoneCall(dest interface{}, args []interface{}, err error) *Call {
+	call := &Call{Args: args, Dest: dest, Err: err, done: make(chan struct{})}
+	close(call.done)
+
+	return call
+}
+
+// NewBatch returns an empty Batch of calls made with c
+func (c *Client) NewBatch() *Batch {
+	return NewBatch(c.Go)
+}
+
+// NewBatch returns an empty Batch of calls made with p
+func (p *Pool) NewBatch() *Batch {
+	return NewBatch(p.Go)
+}
+
+// NewBatch returns an empty Batch of calls made with goFn, the Go
+// method of an implementation of API
+func NewBatch(goFn func(dest interface{}, args ...interface{}) *Call) *Batch {
+	return &Batch{goFn: goFn}
+}
+
+// Batch is a set of calls that are made together and waited for
+// together, such as the requests needed at startup
+type Batch struct {
+	goFn  func(dest interface{}, args ...interface{}) *Call
+	calls []*Call
+}
+
+// Add makes a call as Go does and adds it to the batch
+func (b *Batch) Add(dest interface{}, args ...interface{}) *Call {
+	call := b.goFn(dest, args...)
+	b.calls = append(b.calls, call)
+
+	return call
+}
+
+// Calls returns the calls of the batch in the order they were added
+func (b *Batch) Calls() []*Call {
+	return b.calls
+}
+
+// Wait waits for every call of the batch to be done and returns the
+// error of the first one added that failed
+func (b *Batch) Wait() error {
+	var err error
+	for _, call := range b.calls {
+		if cerr := call.Wait(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
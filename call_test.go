@@ -0,0 +1,126 @@
+package watchman
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type echo struct {
+	Args []string `bser:"args"`
+}
+
+func TestGo(t *testing.T) {
+	c := &Client{Dial: fakeServer{Handler: echoHandler}.Dial, Pipeline: 4}
+	defer c.Close()
+
+	var data echo
+	call := c.Go(&data, "clock", "/a")
+
+	<-call.Done()
+	if err := call.Wait(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := []string{"clock", "/a"}; !reflect.DeepEqual(expected, data.Args) {
+		t.Fatalf("unexpected response:\n\nexpected = %q\n\nactual = %q", expected, data.Args)
+	}
+	if call.Response.Version != "1.0" {
+		t.Fatalf("unexpected version %q", call.Response.Version)
+	}
+
+	call = c.Go(&data, "fail")
+	if err := call.Wait(); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("expected ErrUnknownCommand, found %v", err)
+	}
+	if call.Response.Error != "unknown command fail" {
+		t.Fatalf("unexpected response error %q", call.Response.Error)
+	}
+}
+
+func TestGoOrder(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+
+	handler := func(args []string) interface{} {
+		mu.Lock()
+		received = append(received, args[1])
+		mu.Unlock()
+
+		return echoHandler(args)
+	}
+
+	c := &Client{Dial: fakeServer{Handler: handler}.Dial, Pipeline: 1}
+	defer c.Close()
+
+	var (
+		expected = make([]string, 20)
+		dest     = make([]echo, len(expected))
+		calls    = make([]*Call, len(expected))
+	)
+
+	for i := range calls {
+		expected[i] = fmt.Sprint(i)
+		calls[i] = c.Go(&dest[i], "echo", expected[i])
+	}
+
+	for i, call := range calls {
+		if err := call.Wait(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if args := []string{"echo", expected[i]}; !reflect.DeepEqual(args, dest[i].Args) {
+			t.Fatalf("unexpected response %d:\n\nexpected = %q\n\nactual = %q", i, args, dest[i].Args)
+		}
+	}
+
+	if !reflect.DeepEqual(expected, received) {
+		t.Fatalf("unexpected request order:\n\nexpected = %q\n\nactual = %q", expected, received)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		err      error
+	}{
+		{"empty", nil, nil},
+		{"ok", []string{"clock", "version", "watch-list"}, nil},
+		{"error", []string{"clock", "fail", "version"}, Error("unknown command fail")},
+	}
+
+	c := &Client{Dial: fakeServer{Handler: echoHandler}.Dial, Pipeline: 4}
+	defer c.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				b    = c.NewBatch()
+				dest = make([]echo, len(tt.commands))
+			)
+
+			for i, cmd := range tt.commands {
+				b.Add(&dest[i], cmd)
+			}
+
+			if err := b.Wait(); err != tt.err {
+				t.Fatalf("unexpected error:\n\nexpected = %v\n\nactual = %v", tt.err, err)
+			}
+			if len(b.Calls()) != len(tt.commands) {
+				t.Fatalf("expected %d calls, found %d", len(tt.commands), len(b.Calls()))
+			}
+
+			for i, cmd := range tt.commands {
+				if cmd == "fail" {
+					continue
+				}
+				if expected := []string{cmd}; !reflect.DeepEqual(expected, dest[i].Args) {
+					t.Fatalf("unexpected response %d:\n\nexpected = %q\n\nactual = %q", i, expected, dest[i].Args)
+				}
+			}
+		})
+	}
+}
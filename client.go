@@ -3,37 +3,64 @@ package watchman
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/jonasi/watchman/bser"
 )
 
-var logPDU = func() bool {
-	return os.Getenv("WATCHMAN_LOG_PDU") != ""
-}()
-
-// Error is a Watchman API error
-type Error string
-
-func (e Error) Error() string {
-	return string(e)
-}
+var errClosed = errors.New("Cannot call send on a closed client")
 
 // Client is a watchman client
 type Client struct {
+	// Sockname is the path of the server's unix socket. If it and
+	// Dial are empty, it is found by running watchman get-sockname.
 	Sockname string
-	enc      *bser.Encoder
+	// Dial, if set, opens the connection to the server in place of
+	// dialing Sockname. It allows the client to run over any stream,
+	// such as a net.Pipe, a forwarded socket or a proxy.
+	Dial func() (io.ReadWriteCloser, error)
+	// Capture, if set, receives every PDU exchanged with the server
+	// in the bser capture file format, so the session can be played
+	// back later with NewReplay
+	Capture io.Writer
+	// Logger, if set, receives diagnostic messages, such as warnings
+	// from the server and unilateral messages that were dropped
+	Logger Logger
+	// LogPDUs, if true, logs every PDU exchanged with the server as
+	// JSON to Logger, or to os.Stderr if Logger isn't set
+	LogPDUs bool
+	// Tracer, if set, is called as requests are made and messages
+	// are exchanged with the server
+	Tracer *Tracer
+	// Interceptors are called, the first outermost, around every
+	// request the client sends
+	Interceptors []Interceptor
+	// Pipeline is the most requests written to the server before their
+	// responses have arrived. Watchman answers requests in order, so
+	// pipelining saves a round trip per request when goroutines share
+	// a client. Values below 2 send one request at a time.
+	Pipeline int
+	// OnWarning, if set, is called with each warning the server sends,
+	// along with the command it was sent in response to. Warnings are
+	// also returned in the Warning field of each command's result.
+	// It is called on the goroutine of the command that received the
+	// warning, or on a goroutine of its own for warnings in subscription
+	// events, so it must be safe for concurrent use.
+	OnWarning func(command, warning string)
+
+	pw       *bser.PDUWriter
 	dec      *bser.Decoder
 	initOnce sync.Once
 	initErr  error
 	inited   int32
 	reqCh    chan interface{}
+	done     chan struct{}
 	cleanup  func() error
 }
 
@@ -41,6 +68,7 @@ type Client struct {
 type sendReq struct {
 	dest  interface{}
 	args  []interface{}
+	resp  *Response
 	errCh chan error
 }
 
@@ -57,21 +85,12 @@ type stopReq struct {
 
 func (c *Client) init() error {
 	c.initOnce.Do(func() {
-		if !atomic.CompareAndSwapInt32(&c.inited, 0, 1) {
-			c.initErr = errors.New("Cannot call send on a closed client")
-			return
-		}
-
-		var err error
-		if c.Sockname == "" {
-			c.Sockname, err = inferSockname()
-			if err != nil {
-				c.initErr = err
-				return
-			}
+		dial := c.Dial
+		if dial == nil {
+			dial = c.dialSock
 		}
 
-		sconn, err := initSock(c.Sockname)
+		sconn, err := dial()
 		if err != nil {
 			c.initErr = err
 			return
@@ -80,8 +99,8 @@ func (c *Client) init() error {
 		c.cleanup = sconn.Close
 		var conn io.ReadWriter = sconn
 
-		if logPDU {
-			tap := bser.NewTap(conn, pduLogger("incoming", os.Stderr), pduLogger("outgoing", os.Stderr))
+		if record := c.recorder(); record != nil {
+			tap := bser.NewRecordingTap(conn, record)
 			c.cleanup = func() error {
 				tap.Untap()
 				return sconn.Close()
@@ -90,38 +109,88 @@ func (c *Client) init() error {
 			conn = tap
 		}
 
-		c.enc = bser.NewEncoder(conn)
-		c.dec = bser.NewDecoder(conn)
+		c.pw = bser.NewPDUWriter(conn)
+		c.dec = bser.NewPDUDecoder(bser.NewPDUReader(conn))
 
 		c.reqCh = make(chan interface{})
+		c.done = make(chan struct{})
 		decCh := make(chan interface{})
 
 		go c.readPDUs(decCh)
 		go c.handleReqs(decCh)
+
+		// only an open client has anything for Close to clean up
+		atomic.StoreInt32(&c.inited, 1)
 	})
 
 	return c.initErr
 }
 
+// recorder returns the function that records the PDUs of the
+// connection, or nil if they aren't needed
+func (c *Client) recorder() func(bser.Record) {
+	var fns []func(bser.Record)
+
+	if c.Capture != nil {
+		cw := bser.NewCaptureWriter(c.Capture)
+		fns = append(fns, func(r bser.Record) {
+			if err := cw.WriteRecord(r); err != nil {
+				c.logf("watchman: error capturing PDU: %s", err)
+			}
+		})
+	}
+	if l := c.pduLogger(); l != nil {
+		fns = append(fns, func(r bser.Record) {
+			logRecord(l, r)
+		})
+	}
+	if c.Tracer != nil && c.Tracer.PDU != nil {
+		fns = append(fns, c.Tracer.PDU)
+	}
+
+	if len(fns) == 0 {
+		return nil
+	}
+
+	return func(r bser.Record) {
+		for _, fn := range fns {
+			fn(r)
+		}
+	}
+}
+
 // Close closes the connection to the watchman server
 func (c *Client) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.inited, 1, 2) {
-		// never been opened!
+		// never been opened, or failed to open!
 		return nil
 	}
 
-	close(c.reqCh)
+	close(c.done)
 
 	return c.cleanup()
 }
 
+// readPDUs sends each message read from the server to ch, until
+// the connection fails and it sends the error, or the client is closed
 func (c *Client) readPDUs(ch chan interface{}) {
 	for {
 		var m bser.RawMessage
-		if err := c.dec.Decode(&m); err != nil {
-			ch <- err
-		} else {
-			ch <- m
+		err := c.dec.Decode(&m)
+
+		var msg interface{} = m
+		if err != nil {
+			msg = err
+		}
+
+		select {
+		case ch <- msg:
+		case <-c.done:
+			return
+		}
+
+		if err != nil {
+			return
 		}
 	}
 }
@@ -134,32 +203,45 @@ type watch struct {
 
 func (c *Client) handleReqs(ch chan interface{}) {
 	var (
-		activeReq  *sendReq
+		inflight   = []*sendReq{}
 		queuedReqs = []*sendReq{}
 		watches    = []*watch{}
+		connErr    error
+		// holds at most one PDU per request in flight, so never blocks
+		writeCh = make(chan []byte, c.pipeline())
 	)
 
+	go c.writePDUs(writeCh, ch)
+
 	processNext := func() {
-		if activeReq != nil || len(queuedReqs) == 0 {
-			return
-		}
+		for len(inflight) < c.pipeline() && len(queuedReqs) > 0 {
+			req := queuedReqs[0]
+			queuedReqs = queuedReqs[1:]
 
-		activeReq = queuedReqs[0]
-		queuedReqs = queuedReqs[1:]
+			// no response can arrive once the connection has failed
+			if connErr != nil {
+				req.errCh <- connErr
+				continue
+			}
 
-		if err := c.enc.Encode(activeReq.args); err != nil {
-			activeReq.errCh <- err
-			activeReq = nil
+			// encode here so a request that can't be encoded is
+			// never counted as waiting for a response
+			b, err := bser.MarshalValue(req.args)
+			if err != nil {
+				req.errCh <- err
+				continue
+			}
+
+			inflight = append(inflight, req)
+			writeCh <- b
 		}
 	}
 
 	for {
 		select {
-		case v, ok := <-c.reqCh:
-			if !ok {
-				return
-			}
-
+		case <-c.done:
+			return
+		case v := <-c.reqCh:
 			switch req := v.(type) {
 
 			// send request - add it to the queue of messages
@@ -173,8 +255,11 @@ func (c *Client) handleReqs(ch chan interface{}) {
 			case recReq:
 				watches = append(watches, &watch{ch: req.rec})
 				go func(idx int, stop chan struct{}) {
-					<-stop
-					c.reqCh <- stopReq{idx}
+					select {
+					case <-stop:
+						c.request(stopReq{idx})
+					case <-c.done:
+					}
 				}(len(watches)-1, req.stop)
 
 			// stop request - remove the watch channel
@@ -191,60 +276,105 @@ func (c *Client) handleReqs(ch chan interface{}) {
 				watches[req.idx] = nil
 			}
 		case v := <-ch:
-			if activeReq == nil {
-				c.handleUnilateral(watches, v)
+			msg, ok := v.(bser.RawMessage)
+			if !ok {
+				err := v.(error)
+				connErr = err
+				if len(inflight) == 0 {
+					c.logf("watchman: connection error: %s", err)
+					c.traceDecodeError(err)
+				}
+
+				// fail the requests in flight and everything queued behind them
+				for _, req := range inflight {
+					req.errCh <- err
+				}
+				inflight = inflight[:0]
+				processNext()
 				continue
 			}
 
-			if err, ok := v.(error); ok {
-				activeReq.errCh <- err
-			} else {
-				activeReq.errCh <- bser.UnmarshalValue(v.(bser.RawMessage), activeReq.dest)
+			if len(inflight) == 0 || isUnilateral(msg) {
+				c.handleUnilateral(watches, msg)
+				continue
 			}
 
-			activeReq = nil
+			// responses arrive in the order the requests were sent
+			req := inflight[0]
+			inflight = inflight[1:]
+			req.errCh <- decodeResponse(msg, req.dest, req.resp)
+
 			processNext()
 		}
 	}
 }
 
-func (c *Client) handleUnilateral(watches []*watch, v interface{}) {
-	// should only ever be this...
-	msg, ok := v.(bser.RawMessage)
-	if !ok {
-		return
+// writePDUs writes each encoded request from reqs, until writing
+// fails and it sends the error to ch
+func (c *Client) writePDUs(reqs <-chan []byte, ch chan<- interface{}) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case b := <-reqs:
+			if err := c.pw.WritePDU(b); err != nil {
+				select {
+				case ch <- err:
+				case <-c.done:
+				}
+				return
+			}
+		}
 	}
+}
 
-	var data struct {
-		base
-		*LogEvent
-		*SubscribeEvent
+// pipeline returns the most requests that may be in flight
+func (c *Client) pipeline() int {
+	if c.Pipeline < 1 {
+		return 1
 	}
 
-	if err := bser.UnmarshalValue(msg, &data); err != nil {
-		// todo(isao) - log?
+	return c.Pipeline
+}
+
+func (c *Client) handleUnilateral(watches []*watch, msg bser.RawMessage) {
+	// route on the keys present without decoding the whole message
+	var (
+		val = msg.Value()
+		d   interface{}
+	)
+
+	switch {
+	case val.Get("log").Exists():
+		d = &LogEvent{}
+	case val.Get("subscription").Exists():
+		d = &SubscribeEvent{}
+	default:
+		c.traceDropped(msg, errors.New("unknown message type"))
 		return
 	}
 
-	var d interface{}
-	if data.LogEvent != nil {
-		d = data.LogEvent
-	} else if data.SubscribeEvent != nil {
-		d = data.SubscribeEvent
+	if err := val.Decode(d); err != nil {
+		c.traceDecodeError(err)
+		c.traceDropped(msg, err)
+		return
 	}
 
-	if d == nil {
-		// unhandled
-		// todo(isao) - log?
-		return
+	// warn asynchronously, as messages are dispatched, so OnWarning
+	// can't hold up reading from the server
+	if ev, ok := d.(*SubscribeEvent); ok && ev.Warning != "" {
+		go c.warn("subscribe", ev.Warning)
 	}
 
 	// dispatch msg too all watchers asynchronously
+	received := false
 	for _, w := range watches {
 		if w == nil {
 			continue
 		}
 
+		received = true
+
 		go func(w *watch, d interface{}) {
 			w.RLock()
 			defer w.RUnlock()
@@ -255,6 +385,30 @@ func (c *Client) handleUnilateral(watches []*watch, v interface{}) {
 			w.ch <- d
 		}(w, d)
 	}
+
+	if !received {
+		c.traceDropped(msg, errors.New("no receivers"))
+	}
+}
+
+// isUnilateral reports whether v is a message the server sent on its
+// own, such as a subscription or log event, rather than a response
+func isUnilateral(msg bser.RawMessage) bool {
+	u, _ := msg.Value().Get("unilateral").AsBool()
+	return u
+}
+
+// dialSock connects to the server's unix socket, asking watchman
+// for its name if Sockname isn't set
+func (c *Client) dialSock() (io.ReadWriteCloser, error) {
+	if c.Sockname == "" {
+		var err error
+		if c.Sockname, err = inferSockname(); err != nil {
+			return nil, err
+		}
+	}
+
+	return initSock(c.Sockname)
 }
 
 func initSock(sock string) (net.Conn, error) {
@@ -284,16 +438,46 @@ func inferSockname() (string, error) {
 	return d["sockname"], nil
 }
 
-// Send makes a client call
+// Send makes a client call through the client's Interceptors
 func (c *Client) Send(dest interface{}, args ...interface{}) error {
+	return c.do(newRequest(dest, args))
+}
+
+// do sends req, tracing it
+func (c *Client) do(req *Request) error {
+	start := time.Now()
+	c.traceRequestStart(req.Args)
+
+	err := c.invoke(req)
+	c.traceRequestDone(req.Args, err, start)
+
+	return err
+}
+
+// send sends args and decodes the response into dest and, if it is
+// set, resp. queued, if set, is called once the request is queued,
+// before the response is waited for.
+func (c *Client) send(dest interface{}, args []interface{}, resp *Response, queued func()) error {
 	if err := c.init(); err != nil {
 		return err
 	}
 
-	r := sendReq{args: args, dest: dest, errCh: make(chan error)}
-	c.reqCh <- r
+	r := sendReq{args: args, dest: dest, resp: resp, errCh: make(chan error, 1)}
+	if err := c.request(r); err != nil {
+		return err
+	}
+
+	// requests are written in the order they are queued
+	if queued != nil {
+		queued()
+	}
 
-	return <-r.errCh
+	select {
+	case err := <-r.errCh:
+		return err
+	case <-c.done:
+		return errClosed
+	}
 }
 
 // Receive listens for unilateral messages from the server on ch.
@@ -303,32 +487,30 @@ func (c *Client) Receive(ch chan<- interface{}) (func(), error) {
 	}
 
 	stop := make(chan struct{})
-	c.reqCh <- recReq{rec: ch, stop: stop}
+	if err := c.request(recReq{rec: ch, stop: stop}); err != nil {
+		return nil, err
+	}
+
 	return func() {
-		stop <- struct{}{}
+		select {
+		case stop <- struct{}{}:
+		case <-c.done:
+		}
 	}, nil
 }
 
+// request hands req to the goroutine running handleReqs
+func (c *Client) request(req interface{}) error {
+	select {
+	case c.reqCh <- req:
+		return nil
+	case <-c.done:
+		return errClosed
+	}
+}
+
 type base struct {
 	Version    string `bser:"version"`
 	Error      Error  `bser:"error"`
-	Warning    string `bser:"warning"`
 	Unilateral bool   `bser:"unilateral"`
 }
-
-func pduLogger(dir string, w io.Writer) func([]byte) {
-	return func(b []byte) {
-		var d interface{}
-		if err := bser.UnmarshalValue(b, &d); err != nil {
-			fmt.Fprintf(w, "[pdu logger - %s - bser unmarshal error]: %s\n", dir, err)
-			return
-		}
-		b, err := json.Marshal(d)
-		if err != nil {
-			fmt.Fprintf(w, "[pdu logger - %s - json marshal error]: %s\n", dir, err)
-			return
-		}
-
-		fmt.Fprintf(w, "[pdu logger - %s]: %s\n", dir, string(b))
-	}
-}
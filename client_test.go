@@ -2,12 +2,15 @@ package watchman
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -81,3 +84,56 @@ func expectErrRegex(t *testing.T, err error, pattern string) {
 		t.Fatalf("Expected error to match \"%s\" but found \"%s\"", pattern, err.Error())
 	}
 }
+
+func TestDial(t *testing.T) {
+	srv := fakeServer{Handler: func(args []string) interface{} {
+		if len(args) != 1 || args[0] != "version" {
+			return map[string]interface{}{"error": fmt.Sprintf("unexpected request %v", args)}
+		}
+
+		return map[string]interface{}{"version": "4.9.0"}
+	}}
+
+	cl := &Client{Dial: srv.Dial}
+	defer cl.Close()
+
+	v, err := cl.Version()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v.Version != "4.9.0" {
+		t.Fatalf("unexpected version %#v", v)
+	}
+
+	cl = &Client{Dial: func() (io.ReadWriteCloser, error) {
+		return nil, errors.New("no route to watchman")
+	}}
+
+	_, err = cl.Version()
+	expectErrEqual(t, err, "no route to watchman")
+
+	// closing a client that failed to connect does nothing
+	if err := cl.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %s", err)
+	}
+}
+
+func TestCloseGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	cl := &Client{Dial: fakeServer{Handler: echoHandler}.Dial}
+	if _, err := cl.Version(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cl.Close()
+
+	// the client's goroutines, and the server's, exit once it is closed
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("expected %d goroutines, found %d:\n%s", before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -6,7 +6,8 @@ import (
 
 // Clock is the return object of the Clock call
 type Clock struct {
-	Clock string `bser:"clock"`
+	Clock   string `bser:"clock"`
+	Warning string `bser:"warning"`
 }
 
 // Clock returns the current clock value for a watched root.
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kind is how a field is encoded by the generated code
+type kind int
+
+const (
+	// kindReflect falls back to the reflection based codec
+	kindReflect kind = iota
+	kindString
+	kindBool
+	kindInt
+	kindInt8
+	kindInt16
+	kindInt32
+	kindInt64
+	kindReal
+	// kindStruct is a struct type that is also being generated
+	kindStruct
+	// kindSlice is a slice of kindStruct
+	kindSlice
+	// kindPtrSlice is a slice of pointers to kindStruct
+	kindPtrSlice
+	kindTime
+	kindDuration
+)
+
+// the builtin types that map to a kind, and the type the value
+// is converted to when calling into the bser package
+var builtins = map[string]struct {
+	kind kind
+	conv string
+}{
+	"string":  {kindString, "string"},
+	"bool":    {kindBool, "bool"},
+	"int":     {kindInt, "int"},
+	"int8":    {kindInt8, "int8"},
+	"int16":   {kindInt16, "int16"},
+	"int32":   {kindInt32, "int32"},
+	"int64":   {kindInt64, "int64"},
+	"float32": {kindReal, "float64"},
+	"float64": {kindReal, "float64"},
+}
+
+type genField struct {
+	// name is the BSER object key
+	name string
+	// path is the selector from the receiver, e.g. "Base.Version"
+	path string
+	// typ is the go type as written in the source
+	typ  string
+	kind kind
+	// elem is the struct type of kindStruct, kindSlice and kindPtrSlice
+	elem string
+	// conv is the type the value is converted to when encoding
+	conv string
+	// unit is the unit of kindTime and kindDuration, e.g. "time.Millisecond"
+	unit string
+	// empty is the condition under which an omitempty field is left
+	// out, or "" if it never is
+	empty string
+}
+
+// units are the tag options selecting the unit of time fields
+var units = map[string]string{
+	"s":  "time.Second",
+	"ms": "time.Millisecond",
+	"us": "time.Microsecond",
+	"ns": "time.Nanosecond",
+}
+
+type generator struct {
+	pkg     string
+	types   map[string]ast.Expr
+	methods map[string]map[string]bool
+	gen     map[string]bool
+	slices  map[string]bool
+	ptrs    map[string]bool
+	buf     bytes.Buffer
+}
+
+// generate parses the package in dir and returns the source of
+// the marshalers for names. output is excluded from parsing.
+func generate(dir, output string, names []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != output
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		types:   map[string]ast.Expr{},
+		methods: map[string]map[string]bool{},
+		gen:     map[string]bool{},
+		slices:  map[string]bool{},
+		ptrs:    map[string]bool{},
+	}
+
+	for _, name := range names {
+		g.gen[name] = true
+	}
+
+	for name, pkg := range pkgs {
+		if !g.collect(pkg) {
+			continue
+		}
+		if g.pkg != "" {
+			return nil, fmt.Errorf("types found in multiple packages: %s, %s", g.pkg, name)
+		}
+		g.pkg = name
+	}
+
+	for _, name := range names {
+		if _, ok := g.types[name].(*ast.StructType); !ok {
+			return nil, fmt.Errorf("struct type %s not found in %s", name, dir)
+		}
+	}
+
+	return g.run(names)
+}
+
+// collect records the type and method declarations of pkg and
+// reports whether it declares any of the requested types
+func (g *generator) collect(pkg *ast.Package) bool {
+	types := map[string]ast.Expr{}
+	methods := map[string]map[string]bool{}
+
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok {
+						types[ts.Name.Name] = ts.Type
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) == 0 {
+					continue
+				}
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if id, ok := recv.(*ast.Ident); ok {
+					if methods[id.Name] == nil {
+						methods[id.Name] = map[string]bool{}
+					}
+					methods[id.Name][decl.Name.Name] = true
+				}
+			}
+		}
+	}
+
+	found := false
+	for name := range g.gen {
+		if _, ok := types[name]; ok {
+			found = true
+		}
+	}
+
+	if found {
+		g.types = types
+		g.methods = methods
+	}
+
+	return found
+}
+
+// fields returns the encoded fields of struct type name, flattening
+// embedded structs breadth first the same way the reflection based codec does
+func (g *generator) fields(name string) ([]genField, error) {
+	type embedded struct {
+		st     *ast.StructType
+		prefix string
+	}
+
+	var (
+		fields = []genField{}
+		index  = map[string]int{}
+		queue  = []embedded{{g.types[name].(*ast.StructType), ""}}
+	)
+
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+
+		for _, f := range e.st.Fields.List {
+			tag := ""
+			if f.Tag != nil {
+				t, err := strconv.Unquote(f.Tag.Value)
+				if err != nil {
+					return nil, err
+				}
+				tag = reflect.StructTag(t).Get("bser")
+			}
+
+			if tag == "-" {
+				continue
+			}
+
+			tag, opts := parseTag(tag)
+
+			if len(f.Names) == 0 {
+				id, ok := f.Type.(*ast.Ident)
+				if !ok {
+					return nil, fmt.Errorf("%s: embedded field %s is not supported", name, exprString(f.Type))
+				}
+				st, ok := g.types[id.Name].(*ast.StructType)
+				if !ok {
+					return nil, fmt.Errorf("%s: embedded field %s is not supported", name, id.Name)
+				}
+				queue = append(queue, embedded{st, e.prefix + id.Name + "."})
+				continue
+			}
+
+			for _, n := range f.Names {
+				if !n.IsExported() {
+					continue
+				}
+
+				gf := g.field(f.Type)
+				if gf.kind == kindTime || gf.kind == kindDuration {
+					gf.unit = "0"
+					for _, opt := range opts {
+						if u, ok := units[opt]; ok {
+							gf.unit = u
+							break
+						}
+					}
+				}
+				gf.name = tag
+				if gf.name == "" {
+					gf.name = n.Name
+				}
+				gf.path = e.prefix + n.Name
+
+				for _, opt := range opts {
+					if opt != "omitempty" {
+						continue
+					}
+					empty, ok := emptyCond(gf, "v."+gf.path)
+					if !ok {
+						return nil, fmt.Errorf("%s: omitempty is not supported on field %s of type %s", name, n.Name, gf.typ)
+					}
+					gf.empty = empty
+				}
+
+				// later fields of the same name win, as they do when decoding
+				if i, ok := index[gf.name]; ok {
+					fields[i] = gf
+					continue
+				}
+				index[gf.name] = len(fields)
+				fields = append(fields, gf)
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// field classifies a field of type expr
+func (g *generator) field(expr ast.Expr) genField {
+	gf := genField{typ: exprString(expr), kind: kindReflect}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if b, ok := builtins[t.Name]; ok {
+			gf.kind, gf.conv = b.kind, b.conv
+			return gf
+		}
+
+		if g.gen[t.Name] {
+			gf.kind, gf.elem = kindStruct, t.Name
+			return gf
+		}
+
+		m := g.methods[t.Name]
+		if m["MarshalBSER"] || m["UnmarshalBSER"] || m["MarshalText"] || m["UnmarshalText"] {
+			return gf
+		}
+
+		// named types with a builtin underlying type
+		if u, ok := g.types[t.Name].(*ast.Ident); ok {
+			if b, ok := builtins[u.Name]; ok {
+				gf.kind, gf.conv = b.kind, b.conv
+			}
+		}
+	case *ast.SelectorExpr:
+		switch exprString(t) {
+		case "time.Time":
+			gf.kind = kindTime
+		case "time.Duration":
+			gf.kind = kindDuration
+		}
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return gf
+		}
+
+		switch elem := t.Elt.(type) {
+		case *ast.Ident:
+			if g.gen[elem.Name] {
+				gf.kind, gf.elem = kindSlice, elem.Name
+				g.slices[elem.Name] = true
+			}
+		case *ast.StarExpr:
+			if id, ok := elem.X.(*ast.Ident); ok && g.gen[id.Name] {
+				gf.kind, gf.elem = kindPtrSlice, id.Name
+				g.ptrs[id.Name] = true
+			}
+		}
+	}
+
+	return gf
+}
+
+// emptyCond returns the condition under which the value x of field f
+// is empty, as the reflection based codec defines it for omitempty.
+// Structs are never empty, so their condition is "".
+func emptyCond(f genField, x string) (string, bool) {
+	switch f.kind {
+	case kindString:
+		return x + ` == ""`, true
+	case kindBool:
+		return "!" + x, true
+	case kindInt, kindInt8, kindInt16, kindInt32, kindInt64, kindReal, kindDuration:
+		return x + " == 0", true
+	case kindTime:
+		return x + ".IsZero()", true
+	case kindStruct:
+		return "", true
+	case kindSlice, kindPtrSlice:
+		return "len(" + x + ") == 0", true
+	}
+
+	switch {
+	case strings.HasPrefix(f.typ, "*"):
+		return x + " == nil", true
+	case strings.HasPrefix(f.typ, "[]"), strings.HasPrefix(f.typ, "map["):
+		return "len(" + x + ") == 0", true
+	default:
+		return "", false
+	}
+}
+
+// missingCond returns the condition under which field f is encoded as
+// missing in a templated array row, or "" if it never is
+func missingCond(f genField) string {
+	var conds []string
+	if f.empty != "" {
+		conds = append(conds, f.empty)
+	}
+	if f.kind == kindReflect && strings.HasPrefix(f.typ, "*") {
+		conds = append(conds, "v."+f.path+" == nil")
+	}
+	return strings.Join(conds, " || ")
+}
+
+func (g *generator) run(names []string) ([]byte, error) {
+	all := map[string][]genField{}
+	for _, name := range names {
+		fields, err := g.fields(name)
+		if err != nil {
+			return nil, err
+		}
+		all[name] = fields
+	}
+
+	g.printf("// Code generated by bsergen; DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", g.pkg)
+	if usesTime(all) {
+		g.printf("import (\n\"time\"\n\n\"github.com/jonasi/watchman/bser\"\n)\n\n")
+	} else {
+		g.printf("import \"github.com/jonasi/watchman/bser\"\n\n")
+	}
+
+	for _, name := range names {
+		g.genType(name, all[name])
+	}
+
+	for _, name := range sortedKeys(g.slices) {
+		g.genSlice(name)
+	}
+
+	for _, name := range sortedKeys(g.ptrs) {
+		g.genPtrSlice(name)
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %s\n%s", err, g.buf.String())
+	}
+
+	return src, nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) genType(name string, fields []genField) {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = strconv.Quote(f.name)
+	}
+
+	g.printf("var (\n")
+	g.printf("bser%sNames = []string{%s}\n", name, strings.Join(quoted, ", "))
+	g.printf("bser%sFields = bser.NewFieldSet(bser%sNames...)\n", name, name)
+	g.printf(")\n\n")
+
+	// marshal
+	g.printf("// MarshalBSER implements bser.Marshaler\n")
+	g.printf("func (v %s) MarshalBSER() ([]byte, error) {\n", name)
+	g.printf("return v.appendBSER(nil)\n")
+	g.printf("}\n\n")
+
+	g.printf("func (v *%s) appendBSER(buf []byte) ([]byte, error) {\n", name)
+	if omitsEmpty(fields) {
+		g.printf("n := %d\n", len(fields))
+		for _, f := range fields {
+			if f.empty != "" {
+				g.printf("if %s {\nn--\n}\n", f.empty)
+			}
+		}
+		g.printf("buf = bser.AppendObjectHeader(buf, n)\n")
+	} else {
+		g.printf("buf = bser.AppendObjectHeader(buf, %d)\n", len(fields))
+	}
+	g.printf("return v.appendBSERFields(buf, true)\n")
+	g.printf("}\n\n")
+
+	g.printf("// appendBSERFields appends the field values, preceded by their keys if keys is true\n")
+	g.printf("func (v *%s) appendBSERFields(buf []byte, keys bool) ([]byte, error) {\n", name)
+	if needsErr(fields) {
+		g.printf("var err error\n")
+	}
+	for _, f := range fields {
+		missing := missingCond(f)
+		if missing == "" {
+			g.printf("if keys {\nbuf = bser.AppendString(buf, %q)\n}\n", f.name)
+			g.genAppend(f)
+			continue
+		}
+
+		g.printf("switch {\n")
+		g.printf("case !keys && (%s):\n", missing)
+		g.printf("buf = bser.AppendMissing(buf)\n")
+		if f.empty != "" {
+			g.printf("case keys && (%s):\n// omitempty\n", f.empty)
+		}
+		g.printf("default:\n")
+		g.printf("if keys {\nbuf = bser.AppendString(buf, %q)\n}\n", f.name)
+		g.genAppend(f)
+		g.printf("}\n")
+	}
+	g.printf("return buf, nil\n")
+	g.printf("}\n\n")
+
+	// unmarshal
+	g.printf("// UnmarshalBSER implements bser.Unmarshaler\n")
+	g.printf("func (v *%s) UnmarshalBSER(b []byte) error {\n", name)
+	g.printf("return v.decodeBSER(bser.NewCursor(b))\n")
+	g.printf("}\n\n")
+
+	g.printf("func (v *%s) decodeBSER(c *bser.Cursor) error {\n", name)
+	g.printf("if c.Null() {\n*v = %s{}\nreturn nil\n}\n\n", name)
+	g.printf("n, err := c.ReadObjectHeader()\n")
+	g.printf("if err != nil {\nreturn err\n}\n\n")
+	g.printf("for i := 0; i < n; i++ {\n")
+	g.printf("key, err := c.ReadKey()\n")
+	g.printf("if err != nil {\nreturn err\n}\n")
+	g.printf("if err := v.decodeBSERField(c, bser%sFields.Index(key), key); err != nil {\nreturn err\n}\n", name)
+	g.printf("}\n\n")
+	g.printf("return nil\n")
+	g.printf("}\n\n")
+
+	g.printf("func (v *%s) decodeBSERField(c *bser.Cursor, i int, key []byte) error {\n", name)
+	g.printf("if c.Missing() {\nreturn nil\n}\n\n")
+	g.printf("switch i {\n")
+	for i, f := range fields {
+		g.printf("case %d:\n", i)
+		g.genDecode(f)
+	}
+	g.printf("default:\nreturn c.UnknownField(key)\n")
+	g.printf("}\n\n")
+	g.printf("return nil\n")
+	g.printf("}\n\n")
+}
+
+// usesTime reports whether the generated code refers to the time package
+func usesTime(all map[string][]genField) bool {
+	for _, fields := range all {
+		for _, f := range fields {
+			if f.unit != "" && f.unit != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func omitsEmpty(fields []genField) bool {
+	for _, f := range fields {
+		if f.empty != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func needsErr(fields []genField) bool {
+	for _, f := range fields {
+		switch f.kind {
+		case kindStruct, kindSlice, kindPtrSlice, kindReflect:
+			return true
+		}
+	}
+	return false
+}
+
+const errCheck = "if err != nil {\nreturn nil, err\n}\n"
+
+func (g *generator) genAppend(f genField) {
+	x := "v." + f.path
+	switch f.kind {
+	case kindString:
+		g.printf("buf = bser.AppendString(buf, %s)\n", conv(f, x))
+	case kindBool:
+		g.printf("buf = bser.AppendBool(buf, %s)\n", conv(f, x))
+	case kindInt:
+		g.printf("buf = bser.AppendInt(buf, %s)\n", conv(f, x))
+	case kindInt8:
+		g.printf("buf = bser.AppendInt8(buf, %s)\n", conv(f, x))
+	case kindInt16:
+		g.printf("buf = bser.AppendInt16(buf, %s)\n", conv(f, x))
+	case kindInt32:
+		g.printf("buf = bser.AppendInt32(buf, %s)\n", conv(f, x))
+	case kindInt64:
+		g.printf("buf = bser.AppendInt64(buf, %s)\n", conv(f, x))
+	case kindReal:
+		g.printf("buf = bser.AppendReal(buf, %s)\n", conv(f, x))
+	case kindTime:
+		g.printf("buf = bser.AppendTime(buf, %s, %s)\n", x, f.unit)
+	case kindDuration:
+		g.printf("buf = bser.AppendDuration(buf, %s, %s)\n", x, f.unit)
+	case kindStruct:
+		g.printf("buf, err = %s.appendBSER(buf)\n", x)
+		g.printf(errCheck)
+	case kindSlice:
+		g.printf("buf, err = bserAppend%sSlice(buf, %s)\n", f.elem, x)
+		g.printf(errCheck)
+	case kindPtrSlice:
+		g.printf("buf, err = bserAppend%sPtrSlice(buf, %s)\n", f.elem, x)
+		g.printf(errCheck)
+	default:
+		g.printf("buf, err = bser.AppendValue(buf, %s)\n", x)
+		g.printf(errCheck)
+	}
+}
+
+func conv(f genField, x string) string {
+	if f.conv == f.typ {
+		return x
+	}
+	return f.conv + "(" + x + ")"
+}
+
+func (g *generator) genDecode(f genField) {
+	x := "v." + f.path
+	// set reads a value of type typ with the cursor method read
+	set := func(read, val, typ string) {
+		g.printf("%s, err := c.%s()\n", val, read)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		if f.typ == typ {
+			g.printf("%s = %s\n", x, val)
+		} else {
+			g.printf("%s = %s(%s)\n", x, f.typ, val)
+		}
+	}
+
+	switch f.kind {
+	case kindString:
+		set("ReadString", "s", "string")
+	case kindBool:
+		set("ReadBool", "b", "bool")
+	case kindInt, kindInt8, kindInt16, kindInt32, kindInt64:
+		set("ReadInt", "n", "int64")
+	case kindReal:
+		set("ReadReal", "f", "float64")
+	case kindTime:
+		g.printf("t, err := c.ReadTime(%s)\n", f.unit)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		g.printf("%s = t\n", x)
+	case kindDuration:
+		g.printf("d, err := c.ReadDuration(%s)\n", f.unit)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		g.printf("%s = d\n", x)
+	case kindStruct:
+		g.printf("return %s.decodeBSER(c)\n", x)
+	case kindSlice:
+		g.printf("s, err := bserDecode%sSlice(c)\n", f.elem)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		g.printf("%s = s\n", x)
+	case kindPtrSlice:
+		g.printf("s, err := bserDecode%sPtrSlice(c)\n", f.elem)
+		g.printf("if err != nil {\nreturn err\n}\n")
+		g.printf("%s = s\n", x)
+	default:
+		g.printf("return c.Decode(&%s)\n", x)
+	}
+}
+
+func (g *generator) genSlice(name string) {
+	g.printf("func bserAppend%sSlice(buf []byte, s []%s) ([]byte, error) {\n", name, name)
+	g.printf("if s == nil {\nreturn bser.AppendNull(buf), nil\n}\n\n")
+	g.printf("var err error\n")
+	g.printf("buf = bser.AppendTemplateHeader(buf, bser%sNames, len(s))\n", name)
+	g.printf("for i := range s {\n")
+	g.printf("if buf, err = s[i].appendBSERFields(buf, false); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n\n")
+	g.printf("return buf, nil\n")
+	g.printf("}\n\n")
+
+	g.printf("func bserDecode%sSlice(c *bser.Cursor) ([]%s, error) {\n", name, name)
+	g.printf("t, err := c.Peek()\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("switch t {\n")
+	g.printf("case bser.TypeNull:\n")
+	g.printf("c.Null()\n")
+	g.printf("return nil, nil\n")
+	g.printf("case bser.TypeTemplate:\n")
+	g.printf("names, n, err := c.ReadTemplateHeader()\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("var (\nkeys = make([][]byte, len(names))\nidx = make([]int, len(names))\n)\n")
+	g.printf("for j, name := range names {\n")
+	g.printf("keys[j] = []byte(name)\n")
+	g.printf("idx[j] = bser%sFields.Index(keys[j])\n", name)
+	g.printf("}\n\n")
+	g.printf("s := make([]%s, n)\n", name)
+	g.printf("for i := range s {\n")
+	g.printf("for j := range names {\n")
+	g.printf("if err := s[i].decodeBSERField(c, idx[j], keys[j]); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n")
+	g.printf("}\n\n")
+	g.printf("return s, nil\n")
+	g.printf("default:\n")
+	g.printf("n, err := c.ReadArrayHeader()\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("s := make([]%s, n)\n", name)
+	g.printf("for i := range s {\n")
+	g.printf("if err := s[i].decodeBSER(c); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n\n")
+	g.printf("return s, nil\n")
+	g.printf("}\n")
+	g.printf("}\n\n")
+}
+
+func (g *generator) genPtrSlice(name string) {
+	g.printf("func bserAppend%sPtrSlice(buf []byte, s []*%s) ([]byte, error) {\n", name, name)
+	g.printf("if s == nil {\nreturn bser.AppendNull(buf), nil\n}\n\n")
+	g.printf("var err error\n")
+	g.printf("for _, v := range s {\n")
+	g.printf("if v == nil {\n")
+	g.printf("// can't use template encoding if any values nil\n")
+	g.printf("buf = bser.AppendArrayHeader(buf, len(s))\n")
+	g.printf("for _, v := range s {\n")
+	g.printf("if v == nil {\nbuf = bser.AppendNull(buf)\n} else if buf, err = v.appendBSER(buf); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n")
+	g.printf("return buf, nil\n")
+	g.printf("}\n")
+	g.printf("}\n\n")
+	g.printf("buf = bser.AppendTemplateHeader(buf, bser%sNames, len(s))\n", name)
+	g.printf("for _, v := range s {\n")
+	g.printf("if buf, err = v.appendBSERFields(buf, false); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n\n")
+	g.printf("return buf, nil\n")
+	g.printf("}\n\n")
+
+	g.printf("func bserDecode%sPtrSlice(c *bser.Cursor) ([]*%s, error) {\n", name, name)
+	g.printf("t, err := c.Peek()\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("switch t {\n")
+	g.printf("case bser.TypeNull:\n")
+	g.printf("c.Null()\n")
+	g.printf("return nil, nil\n")
+	g.printf("case bser.TypeTemplate:\n")
+	g.printf("names, n, err := c.ReadTemplateHeader()\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("var (\nkeys = make([][]byte, len(names))\nidx = make([]int, len(names))\n)\n")
+	g.printf("for j, name := range names {\n")
+	g.printf("keys[j] = []byte(name)\n")
+	g.printf("idx[j] = bser%sFields.Index(keys[j])\n", name)
+	g.printf("}\n\n")
+	g.printf("s := make([]*%s, n)\n", name)
+	g.printf("for i := range s {\n")
+	g.printf("s[i] = &%s{}\n", name)
+	g.printf("for j := range names {\n")
+	g.printf("if err := s[i].decodeBSERField(c, idx[j], keys[j]); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n")
+	g.printf("}\n\n")
+	g.printf("return s, nil\n")
+	g.printf("default:\n")
+	g.printf("n, err := c.ReadArrayHeader()\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\n\n")
+	g.printf("s := make([]*%s, n)\n", name)
+	g.printf("for i := range s {\n")
+	g.printf("if c.Null() {\ncontinue\n}\n")
+	g.printf("s[i] = &%s{}\n", name)
+	g.printf("if err := s[i].decodeBSER(c); err != nil {\nreturn nil, err\n}\n")
+	g.printf("}\n\n")
+	g.printf("return s, nil\n")
+	g.printf("}\n")
+	g.printf("}\n\n")
+}
+
+// parseTag splits a field tag into its name and options
+func parseTag(tag string) (string, []string) {
+	parts := strings.Split(tag, ",")
+	return parts[0], parts[1:]
+}
+
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), expr); err != nil {
+		return fmt.Sprintf("%T", expr)
+	}
+	return buf.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// exampleDir is a package built with the code bsergen generates for it,
+// which its tests check against the reflection based encoder
+var exampleDir = filepath.Join("internal", "example")
+
+func TestGenerate(t *testing.T) {
+	const output = "example_bser.go"
+
+	expected, err := ioutil.ReadFile(filepath.Join(exampleDir, output))
+	if err != nil {
+		t.Fatalf("unexpected error reading golden file: %s", err)
+	}
+
+	actual, err := generate(exampleDir, output, []string{"Event", "File"})
+	if err != nil {
+		t.Fatalf("unexpected error generating: %s", err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("generated code does not match %s, regenerate with:\n\tgo generate ./cmd/bsergen/internal/example\n\nactual:\n%s", output, actual)
+	}
+}
+
+var generateErrTests = map[string][]string{
+	"missing_type":     {"Missing"},
+	"non_struct":       {"Mode"},
+	"unsupported_ptr":  {"PtrEmbed"},
+	"unsupported_omit": {"BadOmit"},
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for testName, types := range generateErrTests {
+		t.Run(testName, func(t *testing.T) {
+			if _, err := generate(exampleDir, "example_bser.go", types); err == nil {
+				t.Fatal("unexpectedly no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Package example holds the types bsergen is tested with. Its tests
+// check the generated code against the reflection based encoder.
+package example
+
+//go:generate go run github.com/jonasi/watchman/cmd/bsergen --type=Event,File --output=example_bser.go
+
+import "time"
+
+type Mode int
+
+type Error string
+
+type custom string
+
+type level string
+
+func (l level) MarshalText() ([]byte, error) { return []byte(l), nil }
+
+func (c custom) MarshalBSER() ([]byte, error) { return []byte{0x0a}, nil }
+
+type base struct {
+	Version string `bser:"version"`
+	Error   Error  `bser:"error"`
+}
+
+// Event is a subscription style payload
+type Event struct {
+	base
+	Clock    string            `bser:"clock"`
+	Files    []File            `bser:"files"`
+	Ptrs     []*File           `bser:"ptrs"`
+	Latest   File              `bser:"latest"`
+	Ratio    float32           `bser:"ratio"`
+	Count    int64             `bser:"count"`
+	Meta     map[string]string `bser:"meta"`
+	Custom   custom            `bser:"custom"`
+	Level    level             `bser:"level"`
+	Settle   time.Duration     `bser:"settle"`
+	Ignored  string            `bser:"-"`
+	internal string
+}
+
+// File is a file in an Event
+type File struct {
+	Name   string    `bser:"name"`
+	Size   int       `bser:"size,omitempty"`
+	Mode   Mode      `bser:"mode"`
+	Exists bool      `bser:"exists"`
+	Mtime  time.Time `bser:"mtime,ms"`
+	Owner  *string   `bser:"owner"`
+	Dev    int32
+	Ino    int8
+}
+
+// PtrEmbed embeds a pointer, which is not supported
+type PtrEmbed struct {
+	*File
+}
+
+// BadOmit can't tell when a custom type is empty
+type BadOmit struct {
+	Custom custom `bser:"custom,omitempty"`
+}
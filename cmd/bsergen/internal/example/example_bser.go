@@ -0,0 +1,508 @@
+// Code generated by bsergen; DO NOT EDIT.
+
+package example
+
+import (
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+var (
+	bserEventNames  = []string{"clock", "files", "ptrs", "latest", "ratio", "count", "meta", "custom", "level", "settle", "version", "error"}
+	bserEventFields = bser.NewFieldSet(bserEventNames...)
+)
+
+// MarshalBSER implements bser.Marshaler
+func (v Event) MarshalBSER() ([]byte, error) {
+	return v.appendBSER(nil)
+}
+
+func (v *Event) appendBSER(buf []byte) ([]byte, error) {
+	buf = bser.AppendObjectHeader(buf, 12)
+	return v.appendBSERFields(buf, true)
+}
+
+// appendBSERFields appends the field values, preceded by their keys if keys is true
+func (v *Event) appendBSERFields(buf []byte, keys bool) ([]byte, error) {
+	var err error
+	if keys {
+		buf = bser.AppendString(buf, "clock")
+	}
+	buf = bser.AppendString(buf, v.Clock)
+	if keys {
+		buf = bser.AppendString(buf, "files")
+	}
+	buf, err = bserAppendFileSlice(buf, v.Files)
+	if err != nil {
+		return nil, err
+	}
+	if keys {
+		buf = bser.AppendString(buf, "ptrs")
+	}
+	buf, err = bserAppendFilePtrSlice(buf, v.Ptrs)
+	if err != nil {
+		return nil, err
+	}
+	if keys {
+		buf = bser.AppendString(buf, "latest")
+	}
+	buf, err = v.Latest.appendBSER(buf)
+	if err != nil {
+		return nil, err
+	}
+	if keys {
+		buf = bser.AppendString(buf, "ratio")
+	}
+	buf = bser.AppendReal(buf, float64(v.Ratio))
+	if keys {
+		buf = bser.AppendString(buf, "count")
+	}
+	buf = bser.AppendInt64(buf, v.Count)
+	if keys {
+		buf = bser.AppendString(buf, "meta")
+	}
+	buf, err = bser.AppendValue(buf, v.Meta)
+	if err != nil {
+		return nil, err
+	}
+	if keys {
+		buf = bser.AppendString(buf, "custom")
+	}
+	buf, err = bser.AppendValue(buf, v.Custom)
+	if err != nil {
+		return nil, err
+	}
+	if keys {
+		buf = bser.AppendString(buf, "level")
+	}
+	buf, err = bser.AppendValue(buf, v.Level)
+	if err != nil {
+		return nil, err
+	}
+	if keys {
+		buf = bser.AppendString(buf, "settle")
+	}
+	buf = bser.AppendDuration(buf, v.Settle, 0)
+	if keys {
+		buf = bser.AppendString(buf, "version")
+	}
+	buf = bser.AppendString(buf, v.base.Version)
+	if keys {
+		buf = bser.AppendString(buf, "error")
+	}
+	buf = bser.AppendString(buf, string(v.base.Error))
+	return buf, nil
+}
+
+// UnmarshalBSER implements bser.Unmarshaler
+func (v *Event) UnmarshalBSER(b []byte) error {
+	return v.decodeBSER(bser.NewCursor(b))
+}
+
+func (v *Event) decodeBSER(c *bser.Cursor) error {
+	if c.Null() {
+		*v = Event{}
+		return nil
+	}
+
+	n, err := c.ReadObjectHeader()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		key, err := c.ReadKey()
+		if err != nil {
+			return err
+		}
+		if err := v.decodeBSERField(c, bserEventFields.Index(key), key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *Event) decodeBSERField(c *bser.Cursor, i int, key []byte) error {
+	if c.Missing() {
+		return nil
+	}
+
+	switch i {
+	case 0:
+		s, err := c.ReadString()
+		if err != nil {
+			return err
+		}
+		v.Clock = s
+	case 1:
+		s, err := bserDecodeFileSlice(c)
+		if err != nil {
+			return err
+		}
+		v.Files = s
+	case 2:
+		s, err := bserDecodeFilePtrSlice(c)
+		if err != nil {
+			return err
+		}
+		v.Ptrs = s
+	case 3:
+		return v.Latest.decodeBSER(c)
+	case 4:
+		f, err := c.ReadReal()
+		if err != nil {
+			return err
+		}
+		v.Ratio = float32(f)
+	case 5:
+		n, err := c.ReadInt()
+		if err != nil {
+			return err
+		}
+		v.Count = n
+	case 6:
+		return c.Decode(&v.Meta)
+	case 7:
+		return c.Decode(&v.Custom)
+	case 8:
+		return c.Decode(&v.Level)
+	case 9:
+		d, err := c.ReadDuration(0)
+		if err != nil {
+			return err
+		}
+		v.Settle = d
+	case 10:
+		s, err := c.ReadString()
+		if err != nil {
+			return err
+		}
+		v.base.Version = s
+	case 11:
+		s, err := c.ReadString()
+		if err != nil {
+			return err
+		}
+		v.base.Error = Error(s)
+	default:
+		return c.UnknownField(key)
+	}
+
+	return nil
+}
+
+var (
+	bserFileNames  = []string{"name", "size", "mode", "exists", "mtime", "owner", "Dev", "Ino"}
+	bserFileFields = bser.NewFieldSet(bserFileNames...)
+)
+
+// MarshalBSER implements bser.Marshaler
+func (v File) MarshalBSER() ([]byte, error) {
+	return v.appendBSER(nil)
+}
+
+func (v *File) appendBSER(buf []byte) ([]byte, error) {
+	n := 8
+	if v.Size == 0 {
+		n--
+	}
+	buf = bser.AppendObjectHeader(buf, n)
+	return v.appendBSERFields(buf, true)
+}
+
+// appendBSERFields appends the field values, preceded by their keys if keys is true
+func (v *File) appendBSERFields(buf []byte, keys bool) ([]byte, error) {
+	var err error
+	if keys {
+		buf = bser.AppendString(buf, "name")
+	}
+	buf = bser.AppendString(buf, v.Name)
+	switch {
+	case !keys && (v.Size == 0):
+		buf = bser.AppendMissing(buf)
+	case keys && (v.Size == 0):
+	// omitempty
+	default:
+		if keys {
+			buf = bser.AppendString(buf, "size")
+		}
+		buf = bser.AppendInt(buf, v.Size)
+	}
+	if keys {
+		buf = bser.AppendString(buf, "mode")
+	}
+	buf = bser.AppendInt(buf, int(v.Mode))
+	if keys {
+		buf = bser.AppendString(buf, "exists")
+	}
+	buf = bser.AppendBool(buf, v.Exists)
+	if keys {
+		buf = bser.AppendString(buf, "mtime")
+	}
+	buf = bser.AppendTime(buf, v.Mtime, time.Millisecond)
+	switch {
+	case !keys && (v.Owner == nil):
+		buf = bser.AppendMissing(buf)
+	default:
+		if keys {
+			buf = bser.AppendString(buf, "owner")
+		}
+		buf, err = bser.AppendValue(buf, v.Owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if keys {
+		buf = bser.AppendString(buf, "Dev")
+	}
+	buf = bser.AppendInt32(buf, v.Dev)
+	if keys {
+		buf = bser.AppendString(buf, "Ino")
+	}
+	buf = bser.AppendInt8(buf, v.Ino)
+	return buf, nil
+}
+
+// UnmarshalBSER implements bser.Unmarshaler
+func (v *File) UnmarshalBSER(b []byte) error {
+	return v.decodeBSER(bser.NewCursor(b))
+}
+
+func (v *File) decodeBSER(c *bser.Cursor) error {
+	if c.Null() {
+		*v = File{}
+		return nil
+	}
+
+	n, err := c.ReadObjectHeader()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		key, err := c.ReadKey()
+		if err != nil {
+			return err
+		}
+		if err := v.decodeBSERField(c, bserFileFields.Index(key), key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *File) decodeBSERField(c *bser.Cursor, i int, key []byte) error {
+	if c.Missing() {
+		return nil
+	}
+
+	switch i {
+	case 0:
+		s, err := c.ReadString()
+		if err != nil {
+			return err
+		}
+		v.Name = s
+	case 1:
+		n, err := c.ReadInt()
+		if err != nil {
+			return err
+		}
+		v.Size = int(n)
+	case 2:
+		n, err := c.ReadInt()
+		if err != nil {
+			return err
+		}
+		v.Mode = Mode(n)
+	case 3:
+		b, err := c.ReadBool()
+		if err != nil {
+			return err
+		}
+		v.Exists = b
+	case 4:
+		t, err := c.ReadTime(time.Millisecond)
+		if err != nil {
+			return err
+		}
+		v.Mtime = t
+	case 5:
+		return c.Decode(&v.Owner)
+	case 6:
+		n, err := c.ReadInt()
+		if err != nil {
+			return err
+		}
+		v.Dev = int32(n)
+	case 7:
+		n, err := c.ReadInt()
+		if err != nil {
+			return err
+		}
+		v.Ino = int8(n)
+	default:
+		return c.UnknownField(key)
+	}
+
+	return nil
+}
+
+func bserAppendFileSlice(buf []byte, s []File) ([]byte, error) {
+	if s == nil {
+		return bser.AppendNull(buf), nil
+	}
+
+	var err error
+	buf = bser.AppendTemplateHeader(buf, bserFileNames, len(s))
+	for i := range s {
+		if buf, err = s[i].appendBSERFields(buf, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+func bserDecodeFileSlice(c *bser.Cursor) ([]File, error) {
+	t, err := c.Peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case bser.TypeNull:
+		c.Null()
+		return nil, nil
+	case bser.TypeTemplate:
+		names, n, err := c.ReadTemplateHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			keys = make([][]byte, len(names))
+			idx  = make([]int, len(names))
+		)
+		for j, name := range names {
+			keys[j] = []byte(name)
+			idx[j] = bserFileFields.Index(keys[j])
+		}
+
+		s := make([]File, n)
+		for i := range s {
+			for j := range names {
+				if err := s[i].decodeBSERField(c, idx[j], keys[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return s, nil
+	default:
+		n, err := c.ReadArrayHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		s := make([]File, n)
+		for i := range s {
+			if err := s[i].decodeBSER(c); err != nil {
+				return nil, err
+			}
+		}
+
+		return s, nil
+	}
+}
+
+func bserAppendFilePtrSlice(buf []byte, s []*File) ([]byte, error) {
+	if s == nil {
+		return bser.AppendNull(buf), nil
+	}
+
+	var err error
+	for _, v := range s {
+		if v == nil {
+			// can't use template encoding if any values nil
+			buf = bser.AppendArrayHeader(buf, len(s))
+			for _, v := range s {
+				if v == nil {
+					buf = bser.AppendNull(buf)
+				} else if buf, err = v.appendBSER(buf); err != nil {
+					return nil, err
+				}
+			}
+			return buf, nil
+		}
+	}
+
+	buf = bser.AppendTemplateHeader(buf, bserFileNames, len(s))
+	for _, v := range s {
+		if buf, err = v.appendBSERFields(buf, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+func bserDecodeFilePtrSlice(c *bser.Cursor) ([]*File, error) {
+	t, err := c.Peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case bser.TypeNull:
+		c.Null()
+		return nil, nil
+	case bser.TypeTemplate:
+		names, n, err := c.ReadTemplateHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			keys = make([][]byte, len(names))
+			idx  = make([]int, len(names))
+		)
+		for j, name := range names {
+			keys[j] = []byte(name)
+			idx[j] = bserFileFields.Index(keys[j])
+		}
+
+		s := make([]*File, n)
+		for i := range s {
+			s[i] = &File{}
+			for j := range names {
+				if err := s[i].decodeBSERField(c, idx[j], keys[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return s, nil
+	default:
+		n, err := c.ReadArrayHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		s := make([]*File, n)
+		for i := range s {
+			if c.Null() {
+				continue
+			}
+			s[i] = &File{}
+			if err := s[i].decodeBSER(c); err != nil {
+				return nil, err
+			}
+		}
+
+		return s, nil
+	}
+}
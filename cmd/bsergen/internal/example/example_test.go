@@ -0,0 +1,143 @@
+package example
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+// plainFile and plainEvent have the fields of File and Event without
+// their generated methods, so bser encodes and decodes them by reflection
+type plainFile struct {
+	Name   string    `bser:"name"`
+	Size   int       `bser:"size,omitempty"`
+	Mode   Mode      `bser:"mode"`
+	Exists bool      `bser:"exists"`
+	Mtime  time.Time `bser:"mtime,ms"`
+	Owner  *string   `bser:"owner"`
+	Dev    int32
+	Ino    int8
+}
+
+type plainEvent struct {
+	base
+	Clock    string            `bser:"clock"`
+	Files    []plainFile       `bser:"files"`
+	Ptrs     []*plainFile      `bser:"ptrs"`
+	Latest   plainFile         `bser:"latest"`
+	Ratio    float32           `bser:"ratio"`
+	Count    int64             `bser:"count"`
+	Meta     map[string]string `bser:"meta"`
+	Custom   custom            `bser:"custom"`
+	Level    level             `bser:"level"`
+	Settle   time.Duration     `bser:"settle"`
+	Ignored  string            `bser:"-"`
+	internal string
+}
+
+func plain(e Event) plainEvent {
+	p := plainEvent{
+		base:   e.base,
+		Clock:  e.Clock,
+		Latest: plainFile(e.Latest),
+		Ratio:  e.Ratio,
+		Count:  e.Count,
+		Meta:   e.Meta,
+		Custom: e.Custom,
+		Level:  e.Level,
+		Settle: e.Settle,
+	}
+
+	if e.Files != nil {
+		p.Files = []plainFile{}
+		for _, f := range e.Files {
+			p.Files = append(p.Files, plainFile(f))
+		}
+	}
+	for _, f := range e.Ptrs {
+		var pf *plainFile
+		if f != nil {
+			pf = (*plainFile)(f)
+		}
+		p.Ptrs = append(p.Ptrs, pf)
+	}
+
+	return p
+}
+
+var (
+	owner = "root"
+	mtime = time.Unix(1518000000, 123000000)
+)
+
+var events = map[string]Event{
+	"empty": {},
+	"full": {
+		base:  base{Version: "4.9.0", Error: "oops"},
+		Clock: "c:1:2",
+		Files: []File{
+			{Name: "a", Size: 300, Mode: 0644, Exists: true, Mtime: mtime, Owner: &owner, Dev: 70000, Ino: -1},
+			{Name: "b", Mode: 0755},
+		},
+		Ptrs:   []*File{{Name: "c", Size: 1 << 40}, nil},
+		Latest: File{Name: "d", Mtime: mtime},
+		Ratio:  0.5,
+		Count:  -200,
+		Meta:   map[string]string{"k": "v"},
+		Level:  "debug",
+		Settle: 20 * time.Millisecond,
+	},
+	"empty_files": {Files: []File{}},
+}
+
+func TestMarshalMatchesReflection(t *testing.T) {
+	for name, e := range events {
+		t.Run(name, func(t *testing.T) {
+			expected, err := bser.MarshalValue(plain(e))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual, err := e.MarshalBSER()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !bytes.Equal(expected, actual) {
+				t.Fatalf("unexpected encoding:\n\nexpected = %v\n\nactual = %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMatchesReflection(t *testing.T) {
+	for name, e := range events {
+		t.Run(name, func(t *testing.T) {
+			// the reflection based encoding, which also exercises the
+			// generated decoder on templated arrays
+			b, err := bser.MarshalValue(plain(e))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var (
+				expected plainEvent
+				actual   Event
+			)
+
+			if err := bser.UnmarshalValue(b, &expected); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := actual.UnmarshalBSER(b); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(expected, plain(actual)) {
+				t.Fatalf("unexpected decoding:\n\nexpected = %#v\n\nactual = %#v", expected, plain(actual))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// bsergen generates reflection free MarshalBSER and UnmarshalBSER
+// implementations for struct types. It is intended to be run via go generate:
+//
+//	//go:generate go run github.com/jonasi/watchman/cmd/bsergen --type=File,Find
+//
+// Fields are named by their bser tags, the same way the reflection based
+// encoder and decoder name them, and slices of generated structs are
+// encoded as templated arrays.
+//
+// As with encoding/json, embedding a generated type in another struct
+// promotes its MarshalBSER and UnmarshalBSER methods to the outer type.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	cmd := &cobra.Command{
+		Use:   "bsergen",
+		Short: "Generate BSER marshalers for struct types",
+	}
+
+	var (
+		types  = cmd.Flags().StringP("type", "t", "", "comma-separated list of type names")
+		output = cmd.Flags().StringP("output", "o", "", "output file name; default <type>_bser.go")
+		dir    = cmd.Flags().StringP("dir", "d", ".", "directory of the package containing the types")
+	)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if *types == "" {
+			return cmd.Usage()
+		}
+
+		names := strings.Split(*types, ",")
+		out := *output
+		if out == "" {
+			out = strings.ToLower(names[0]) + "_bser.go"
+		}
+		out = filepath.Join(*dir, out)
+
+		src, err := generate(*dir, filepath.Base(out), names)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(out, src, 0644)
+	}
+
+	if err := cmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+	"github.com/spf13/cobra"
+)
+
+func bserCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bser",
+		Short: "Inspect BSER encoded data",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "dump [file...]",
+		Short: "Print an annotated listing of each PDU in the files, or stdin if none are given",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return dumpPDUs(os.Stdout, os.Stdin)
+			}
+
+			for _, name := range args {
+				if err := dumpFile(os.Stdout, name); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "capture [file...]",
+		Short: "Print each record of the capture files, or stdin if none are given, as JSON",
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return printCapture(os.Stdout, os.Stdin)
+			}
+
+			for _, name := range args {
+				if err := printCaptureFile(os.Stdout, name); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func dumpFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(w, "# %s\n", name)
+	return dumpPDUs(w, f)
+}
+
+// dumpPDUs dumps each PDU read from r, separated by blank lines. A PDU
+// that is cut short or corrupt is dumped as far as it was read before
+// its error is returned.
+func dumpPDUs(w io.Writer, r io.Reader) error {
+	var (
+		raw bytes.Buffer
+		pr  = bser.NewPDUReader(io.TeeReader(r, &raw))
+	)
+
+	for i := 0; ; i++ {
+		raw.Reset()
+
+		_, err := pr.ReadPDU(nil)
+		if err == io.EOF {
+			return nil
+		}
+
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		// the reader consumes exactly one PDU, so raw holds it whole
+		if derr := bser.Dump(w, raw.Bytes()); err == nil && derr != nil {
+			err = derr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func printCaptureFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(w, "# %s\n", name)
+	return printCapture(w, f)
+}
+
+// printCapture prints a line for each record read from r with its
+// sequence number, time, direction and value as JSON
+func printCapture(w io.Writer, r io.Reader) error {
+	cr := bser.NewCaptureReader(r)
+	for {
+		rec, err := cr.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		body, err := rec.Body()
+		if err != nil {
+			return err
+		}
+
+		js, err := bser.AppendJSON(nil, body)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", rec.Seq, rec.Time.Format(time.RFC3339Nano), rec.Direction, js)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 
 	"github.com/jonasi/watchman"
+	"github.com/jonasi/watchman/bser"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +21,8 @@ func main() {
 		persistent = cmd.Flags().BoolP("persistent", "p", false, "")
 	)
 
+	cmd.AddCommand(bserCommand())
+
 	cmd.RunE = func(*cobra.Command, []string) error {
 		if *js != "" {
 			if *persistent {
@@ -36,36 +40,36 @@ func main() {
 	}
 }
 
+// newClient returns a client that logs every PDU when the
+// WATCHMAN_LOG_PDU environment variable is set
+func newClient() *watchman.Client {
+	return &watchman.Client{LogPDUs: os.Getenv("WATCHMAN_LOG_PDU") != ""}
+}
+
 func doSend(js string) error {
-	cl := &watchman.Client{}
-	var in []interface{}
-	if err := json.Unmarshal([]byte(js), &in); err != nil {
+	cl := newClient()
+	in, err := parseCommand(js)
+	if err != nil {
 		return err
 	}
 
-	var out interface{}
+	var out bser.RawMessage
 	if err := cl.Send(&out, in...); err != nil {
 		return err
 	}
 
-	b, err := json.MarshalIndent(out, "", "    ")
-	if err != nil {
-		return err
-	}
-
-	_, err = fmt.Fprint(os.Stdout, string(b)+"\n")
-	return err
+	return printBSER(out)
 }
 
 func doSendPersistent(js string) error {
-	cl := &watchman.Client{}
-	var in []interface{}
-	if err := json.Unmarshal([]byte(js), &in); err != nil {
+	cl := newClient()
+	in, err := parseCommand(js)
+	if err != nil {
 		return err
 	}
 
 	var (
-		out interface{}
+		out bser.RawMessage
 		ch  = make(chan interface{})
 	)
 
@@ -86,15 +90,56 @@ func doSendPersistent(js string) error {
 		return err
 	}
 
-	b, err := json.MarshalIndent(out, "", "    ")
-	if err != nil {
+	if err := printBSER(out); err != nil {
 		return err
 	}
 
-	_, err = fmt.Fprint(os.Stdout, string(b)+"\n")
+	select {}
+}
+
+// parseCommand converts a JSON command array to BSER
+// arguments, keeping integers and reals distinct
+func parseCommand(js string) ([]interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal([]byte(js), &args); err != nil {
+		return nil, err
+	}
+
+	in := make([]interface{}, len(args))
+	for i, arg := range args {
+		// the command name is passed as a string, so requests are
+		// traced and intercepted by name
+		if i == 0 {
+			var cmd string
+			if err := json.Unmarshal(arg, &cmd); err == nil {
+				in[i] = cmd
+				continue
+			}
+		}
+
+		b, err := bser.AppendFromJSON(nil, arg)
+		if err != nil {
+			return nil, err
+		}
+		in[i] = bser.RawMessage(b)
+	}
+
+	return in, nil
+}
+
+// printBSER writes b to stdout as indented JSON
+func printBSER(b bser.RawMessage) error {
+	js, err := bser.AppendJSON(nil, b)
 	if err != nil {
 		return err
 	}
 
-	select {}
+	var out bytes.Buffer
+	if err := json.Indent(&out, js, "", "    "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+
+	_, err = out.WriteTo(os.Stdout)
+	return err
 }
@@ -0,0 +1,71 @@
+package watchman
+
+import (
+	"errors"
+	"strings"
+)
+
+// The classes of common errors reported by the server. An Error matches
+// them with errors.Is, so callers can check for one, such as
+//
+//	if errors.Is(err, watchman.ErrRootNotWatched) {
+//		// watch the root and try again
+//	}
+var (
+	// ErrRootNotWatched is a request for a root that isn't watched
+	ErrRootNotWatched = errors.New("watchman: root is not watched")
+	// ErrUnknownCommand is a request for a command the server doesn't support
+	ErrUnknownCommand = errors.New("watchman: unknown command")
+	// ErrInvalidExpression is a query whose expression can't be parsed
+	ErrInvalidExpression = errors.New("watchman: invalid expression")
+	// ErrSyncTimeout is a request that timed out waiting for the
+	// watcher to catch up with the filesystem
+	ErrSyncTimeout = errors.New("watchman: timed out synchronizing with the filesystem")
+	// ErrRootRestricted is a watch refused because none of the files
+	// of the root_files configuration are present
+	ErrRootRestricted = errors.New("watchman: root restricted by root_files")
+	// ErrRecrawling is a request refused while the root is recrawled
+	ErrRecrawling = errors.New("watchman: root is being recrawled")
+)
+
+// errorClasses match the messages of the server's errors to the
+// errors.Is targets above
+var errorClasses = []struct {
+	err      error
+	patterns []string
+}{
+	{ErrRootNotWatched, []string{"is not watched"}},
+	{ErrUnknownCommand, []string{"unknown command"}},
+	{ErrInvalidExpression, []string{"failed to parse query", "unknown expression term", "invalid expression"}},
+	{ErrSyncTimeout, []string{"timed out waiting for cookie", "synchronization failed"}},
+	{ErrRootRestricted, []string{"root_files"}},
+	// not just "recrawl", which also appears in recrawl warnings and paths
+	{ErrRecrawling, []string{"is being recrawled", "recrawl in progress"}},
+}
+
+// Error is a Watchman API error
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Is reports whether e is of the class of server errors target,
+// one of the Err variables of this package
+func (e Error) Is(target error) bool {
+	msg := strings.ToLower(string(e))
+	for _, c := range errorClasses {
+		if c.err != target {
+			continue
+		}
+
+		for _, p := range c.patterns {
+			if strings.Contains(msg, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return false
+}
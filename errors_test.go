@@ -0,0 +1,77 @@
+package watchman
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+var errorClassTests = map[string]struct {
+	msg      string
+	expected error
+}{
+	"not_watched": {
+		msg:      "unable to resolve root /tmp/x: directory /tmp/x is not watched",
+		expected: ErrRootNotWatched,
+	},
+	"unknown_command": {
+		msg:      "unknown command bogus",
+		expected: ErrUnknownCommand,
+	},
+	"parse_query": {
+		msg:      "failed to parse query: must use [\"match\", \"pattern\" ...]",
+		expected: ErrInvalidExpression,
+	},
+	"unknown_term": {
+		msg:      "failed to parse query: unknown expression term 'bogus'",
+		expected: ErrInvalidExpression,
+	},
+	"sync_timeout": {
+		msg:      "synchronization failed: syncToNow: timed out waiting for cookie file to be observed by watcher within 60000 milliseconds: Timed out",
+		expected: ErrSyncTimeout,
+	},
+	"root_files": {
+		msg:      "unable to resolve root /tmp/x: Your watchman administrator has configured watchman to prevent watching path `/tmp/x`.  None of the files listed in global config root_files are present and enforce_root_files is set to true.",
+		expected: ErrRootRestricted,
+	},
+	"recrawl": {
+		msg:      "Recrawl in progress for /tmp/x",
+		expected: ErrRecrawling,
+	},
+	"being_recrawled": {
+		msg:      "unable to resolve root /tmp/x: root /tmp/x is being recrawled",
+		expected: ErrRecrawling,
+	},
+	"recrawl_path": {
+		msg:      "unable to resolve root /tmp/recrawl: directory /tmp/recrawl is not watched",
+		expected: ErrRootNotWatched,
+	},
+	"recrawl_warning": {
+		msg: "Recrawled this watch 3 times, most recently because:\n/tmp/x: kFSEventStreamEventFlagUserDropped",
+	},
+	"other": {
+		msg: "invalid log level for log-level",
+	},
+}
+
+func TestErrorClasses(t *testing.T) {
+	classes := []error{ErrRootNotWatched, ErrUnknownCommand, ErrInvalidExpression, ErrSyncTimeout, ErrRootRestricted, ErrRecrawling}
+
+	for testName, testCase := range errorClassTests {
+		t.Run(testName, func(t *testing.T) {
+			// as returned by a typed command and wrapped by the caller
+			err := fmt.Errorf("watching project: %w", Error(testCase.msg))
+
+			for _, class := range classes {
+				if actual := errors.Is(err, class); actual != (class == testCase.expected) {
+					t.Errorf("unexpected errors.Is(%q, %q) = %t", testCase.msg, class, actual)
+				}
+			}
+
+			var e Error
+			if !errors.As(err, &e) || string(e) != testCase.msg {
+				t.Errorf("unexpected errors.As result %q", e)
+			}
+		})
+	}
+}
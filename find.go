@@ -6,8 +6,9 @@ import (
 
 // Find is the return object of the Find call
 type Find struct {
-	Clock string `bser:"clock"`
-	Files []File `bser:"files"`
+	Clock   string `bser:"clock"`
+	Files   []File `bser:"files"`
+	Warning string `bser:"warning"`
 }
 
 // File represents a file on the filesystem
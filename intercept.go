@@ -0,0 +1,106 @@
+package watchman
+
+import (
+	"reflect"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+// Request is a request being sent by a Client
+type Request struct {
+	// Command is the name of the command, the first of Args
+	Command string
+	// Args are the arguments sent to the server, command included
+	Args []interface{}
+	// Dest is the value the response is decoded into
+	Dest interface{}
+	// Response holds the fields common to every response, once it
+	// has been received. Errors reported by watchman are found here
+	// rather than being returned by Invoker.
+	Response Response
+
+	attempts     int
+	wantResponse bool   // decode Response even without interceptors
+	queued       func() // called as each attempt is queued, if set
+}
+
+// Response holds the fields common to every watchman response
+type Response struct {
+	Version string `bser:"version"`
+	Error   Error  `bser:"error"`
+	Warning string `bser:"warning"`
+}
+
+// Invoker sends a request and decodes its response
+type Invoker func(req *Request) error
+
+// Interceptor is called for every request sent by a Client, including
+// those of its typed methods. It sends the request by calling next,
+// which it is free to do after inspecting or changing req, more than
+// once to retry it, or not at all. When next is called again for the
+// same request, req.Dest is reset to its zero value first.
+type Interceptor func(req *Request, next Invoker) error
+
+// invoke sends req through the client's interceptors
+func (c *Client) invoke(req *Request) error {
+	next := c.sendRequest
+	for i := len(c.Interceptors) - 1; i >= 0; i-- {
+		next = intercept(c.Interceptors[i], next)
+	}
+
+	return next(req)
+}
+
+func intercept(in Interceptor, next Invoker) Invoker {
+	return func(req *Request) error {
+		return in(req, next)
+	}
+}
+
+// sendRequest is the Invoker at the end of every chain of interceptors
+func (c *Client) sendRequest(req *Request) error {
+	req.attempts++
+	if req.attempts > 1 {
+		if v := reflect.ValueOf(req.Dest); v.Kind() == reflect.Ptr && !v.IsNil() {
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		}
+	}
+
+	req.Response = Response{}
+
+	var resp *Response
+	if req.wantResponse || len(c.Interceptors) > 0 || c.OnWarning != nil || c.Logger != nil {
+		resp = &req.Response
+	}
+
+	if err := c.send(req.Dest, req.Args, resp, req.queued); err != nil {
+		return err
+	}
+
+	if req.Response.Warning != "" {
+		c.warn(req.Command, req.Response.Warning)
+	}
+
+	return nil
+}
+
+// newRequest returns the Request sent for args
+func newRequest(dest interface{}, args []interface{}) *Request {
+	req := &Request{Args: args, Dest: dest}
+	if len(args) > 0 {
+		req.Command, _ = args[0].(string)
+	}
+
+	return req
+}
+
+// decodeResponse decodes msg into dest and, if it is set, resp
+func decodeResponse(msg bser.RawMessage, dest interface{}, resp *Response) error {
+	if resp != nil {
+		if err := bser.UnmarshalValue(msg, resp); err != nil {
+			return err
+		}
+	}
+
+	return bser.UnmarshalValue(msg, dest)
+}
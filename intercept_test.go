@@ -0,0 +1,73 @@
+package watchman
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0", "error": "server busy"}),
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0", "warning": "recrawled"}),
+		sent([]interface{}{"log-level", "error"}),
+		received(map[string]interface{}{"version": "4.9.0", "log_level": "error"}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	var (
+		calls    []string
+		warnings []string
+	)
+
+	cl := rp.Client()
+	cl.Interceptors = []Interceptor{
+		// audit every command and its response
+		func(req *Request, next Invoker) error {
+			calls = append(calls, req.Command)
+			err := next(req)
+			if req.Response.Warning != "" {
+				warnings = append(warnings, req.Response.Warning)
+			}
+			return err
+		},
+		// retry once when the server is busy
+		func(req *Request, next Invoker) error {
+			if err := next(req); err != nil || req.Response.Error != "server busy" {
+				return err
+			}
+			return next(req)
+		},
+		// rewrite requests
+		func(req *Request, next Invoker) error {
+			if req.Command == "log-level" {
+				req.Args = []interface{}{"log-level", LogLevelError}
+			}
+			return next(req)
+		},
+	}
+	defer cl.Close()
+
+	v, err := cl.Version()
+	if err != nil || v.Version != "4.9.0" {
+		t.Fatalf("unexpected version %#v, %v", v, err)
+	}
+
+	l, err := cl.LogLevel(LogLevelDebug)
+	if err != nil || l.LogLevel != LogLevelError {
+		t.Fatalf("unexpected log level %#v, %v", l, err)
+	}
+
+	if expected := []string{"version", "log-level"}; !reflect.DeepEqual(expected, calls) {
+		t.Fatalf("unexpected calls:\n\nexpected = %q\n\nactual = %q", expected, calls)
+	}
+	if expected := []string{"recrawled"}; !reflect.DeepEqual(expected, warnings) {
+		t.Fatalf("unexpected warnings:\n\nexpected = %q\n\nactual = %q", expected, warnings)
+	}
+	if err := rp.Err(); err != nil {
+		t.Fatalf("unexpected replay error: %s", err)
+	}
+}
@@ -10,6 +10,7 @@ const (
 // LogLevel is the return object of SetLogLevel
 type LogLevel struct {
 	LogLevel string `bser:"log_level"`
+	Warning  string `bser:"warning"`
 }
 
 // LogEvent is the event sent for log messages
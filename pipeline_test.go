@@ -0,0 +1,90 @@
+package watchman
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	var pipelineTests = map[string]struct {
+		pipeline int
+		// whether more than one request is expected to be written
+		// before its response arrives
+		pipelined bool
+	}{
+		"off":       {pipeline: 1},
+		"pipelined": {pipeline: 8, pipelined: true},
+	}
+
+	for testName, testCase := range pipelineTests {
+		t.Run(testName, func(t *testing.T) {
+			var (
+				peak int64
+				srv  = fakeServer{Handler: echoHandler, Latency: time.Millisecond, MaxUnanswered: &peak}
+				cl   = &Client{Pipeline: testCase.pipeline, Dial: srv.Dial}
+				wg   sync.WaitGroup
+			)
+			defer cl.Close()
+
+			for i := 0; i < 32; i++ {
+				wg.Add(1)
+				go func(token string) {
+					defer wg.Done()
+
+					// every caller receives the response to its own request
+					var data echo
+					if err := cl.Send(&data, "echo", token); err != nil {
+						t.Errorf("unexpected error: %s", err)
+						return
+					}
+					if expected := []string{"echo", token}; !reflect.DeepEqual(expected, data.Args) {
+						t.Errorf("unexpected response:\n\nexpected = %q\n\nactual = %q", expected, data.Args)
+					}
+				}(strconv.Itoa(i))
+			}
+
+			wg.Wait()
+
+			max := atomic.LoadInt64(&peak)
+			if max > 1 != testCase.pipelined {
+				t.Fatalf("unexpected number of unanswered requests with Pipeline %d: %d", testCase.pipeline, max)
+			}
+			if max > int64(testCase.pipeline) {
+				t.Fatalf("expected at most %d unanswered requests, found %d", testCase.pipeline, max)
+			}
+		})
+	}
+}
+
+func BenchmarkClock(b *testing.B) {
+	for _, pipeline := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("pipeline=%d", pipeline), func(b *testing.B) {
+			// the server answers as soon as it can, so the benchmark
+			// measures the client rather than timer granularity
+			srv := fakeServer{Handler: clockHandler()}
+
+			cl := &Client{Pipeline: pipeline, Dial: srv.Dial}
+			defer cl.Close()
+
+			path := os.TempDir()
+
+			b.SetParallelism(64)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := cl.Clock(path); err != nil {
+						b.Errorf("unexpected error: %s", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
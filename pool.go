@@ -0,0 +1,146 @@
+package watchman
+
+import (
+	"sync/atomic"
+)
+
+// pinnedCommands are the commands whose effects last for the life of
+// the connection they are sent on, so are always sent on the same one
+var pinnedCommands = map[string]bool{
+	"subscribe":           true,
+	"unsubscribe":         true,
+	"flush-subscriptions": true,
+	"log-level":           true,
+	"state-enter":         true,
+	"state-leave":         true,
+}
+
+// NewPool returns a Pool spreading requests over size connections, each
+// made by a Client returned by newClient. If newClient is nil, zero
+// Clients are used. Connections are opened as they are first needed.
+func NewPool(size int, newClient func() *Client) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	if newClient == nil {
+		newClient = func() *Client { return &Client{} }
+	}
+
+	p := &Pool{
+		clients: make([]*Client, size),
+		pinned:  newClient(),
+	}
+	for i := range p.clients {
+		p.clients[i] = newClient()
+	}
+
+	return p
+}
+
+// Pool is a set of connections to the watchman server with the same
+// methods as Client. Requests are sent on each connection in turn,
+// except for subscriptions, log levels and states, which only last as
+// long as their connection does: they are sent on a dedicated
+// connection, which also receives all unilateral messages.
+type Pool struct {
+	clients []*Client
+	pinned  *Client
+	next    uint32
+}
+
+// client returns the Client to send the command in args on
+func (p *Pool) client(args []interface{}) *Client {
+	if len(args) > 0 {
+		if cmd, _ := args[0].(string); pinnedCommands[cmd] {
+			return p.pinned
+		}
+	}
+
+	return p.roundRobin()
+}
+
+func (p *Pool) roundRobin() *Client {
+	n := atomic.AddUint32(&p.next, 1)
+	return p.clients[(n-1)%uint32(len(p.clients))]
+}
+
+// Close closes all the connections of the pool, returning
+// the first error encountered
+func (p *Pool) Close() error {
+	err := p.pinned.Close()
+	for _, c := range p.clients {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Send makes a call on one of the pool's connections
+func (p *Pool) Send(dest interface{}, args ...interface{}) error {
+	return p.client(args).Send(dest, args...)
+}
+
+// Receive listens for unilateral messages from the server on ch.
+// They are received from the pool's dedicated connection.
+func (p *Pool) Receive(ch chan<- interface{}) (func(), error) {
+	return p.pinned.Receive(ch)
+}
+
+// Clock returns the current clock value for a watched root, as Client.Clock does
+func (p *Pool) Clock(path string) (*Clock, error) {
+	return p.roundRobin().Clock(path)
+}
+
+// Find finds files under the specified dir, as Client.Find does
+func (p *Pool) Find(path string, patterns ...string) (*Find, error) {
+	return p.roundRobin().Find(path, patterns...)
+}
+
+// LogLevel changes the log level of the pool's dedicated connection
+func (p *Pool) LogLevel(level string) (*LogLevel, error) {
+	return p.pinned.LogLevel(level)
+}
+
+// Subscribe subscribes to changes against a specified root on the pool's
+// dedicated connection, as Client.Subscribe does
+func (p *Pool) Subscribe(path, name string, expr map[string]interface{}, ch chan<- *SubscribeEvent) (*Subscribe, func(), error) {
+	return p.pinned.Subscribe(path, name, expr, ch)
+}
+
+// Unsubscribe cancels a named subscription made with Subscribe
+func (p *Pool) Unsubscribe(path, name string) (*Unsubscribe, error) {
+	return p.pinned.Unsubscribe(path, name)
+}
+
+// Version returns the version of the watchman service, as Client.Version does
+func (p *Pool) Version() (*Version, error) {
+	return p.roundRobin().Version()
+}
+
+// Watch requests that the specified dir is watched, as Client.Watch does
+func (p *Pool) Watch(path string) (*Watch, error) {
+	return p.roundRobin().Watch(path)
+}
+
+// WatchDel removes a watch, as Client.WatchDel does
+func (p *Pool) WatchDel(path string) (*WatchDel, error) {
+	return p.roundRobin().WatchDel(path)
+}
+
+// WatchDelAll removes all watches, as Client.WatchDelAll does
+func (p *Pool) WatchDelAll(path string) (*WatchDelAll, error) {
+	return p.roundRobin().WatchDelAll(path)
+}
+
+// WatchList returns a list of watched dirs, as Client.WatchList does
+func (p *Pool) WatchList() (*WatchList, error) {
+	return p.roundRobin().WatchList()
+}
+
+// WatchProject requests that the project containing the requested dir is
+// watched, as Client.WatchProject does
+func (p *Pool) WatchProject(path string) (*WatchProject, error) {
+	return p.roundRobin().WatchProject(path)
+}
@@ -0,0 +1,86 @@
+package watchman
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	var n int
+	p := NewPool(3, func() *Client {
+		// each server reports the connection it answered on as its version
+		conn := fmt.Sprintf("conn-%d", n)
+		n++
+
+		return &Client{Dial: fakeServer{Handler: func(args []string) interface{} {
+			resp := map[string]interface{}{"version": conn}
+			if args[0] == "log-level" {
+				resp["log_level"] = args[1]
+			}
+			return resp
+		}}.Dial}
+	})
+	defer p.Close()
+
+	var versions []string
+	for i := 0; i < 6; i++ {
+		v, err := p.Version()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		versions = append(versions, v.Version)
+	}
+
+	// conn-0 is the dedicated connection
+	expected := []string{"conn-1", "conn-2", "conn-3", "conn-1", "conn-2", "conn-3"}
+	if !reflect.DeepEqual(expected, versions) {
+		t.Fatalf("unexpected connections:\n\nexpected = %q\n\nactual = %q", expected, versions)
+	}
+
+	for i := 0; i < 2; i++ {
+		var data base
+		if err := p.Send(&data, "log-level", LogLevelError); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if data.Version != "conn-0" {
+			t.Fatalf("expected log-level to be sent on the dedicated connection, found %s", data.Version)
+		}
+	}
+
+	if l, err := p.LogLevel(LogLevelDebug); err != nil || l.LogLevel != LogLevelDebug {
+		t.Fatalf("unexpected log level %#v, %v", l, err)
+	}
+}
+
+func TestPoolDialError(t *testing.T) {
+	var n int
+	p := NewPool(2, func() *Client {
+		// the second connection fails to dial
+		n++
+		if n == 2 {
+			return &Client{Dial: func() (io.ReadWriteCloser, error) {
+				return nil, errors.New("no route to watchman")
+			}}
+		}
+
+		return &Client{Dial: fakeServer{Handler: echoHandler}.Dial}
+	})
+
+	var errs []string
+	for i := 0; i < 2; i++ {
+		if _, err := p.Version(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if expected := []string{"no route to watchman"}; !reflect.DeepEqual(expected, errs) {
+		t.Fatalf("unexpected errors:\n\nexpected = %q\n\nactual = %q", expected, errs)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %s", err)
+	}
+}
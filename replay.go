@@ -0,0 +1,231 @@
+package watchman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+// ReplayError describes a request that doesn't match the recorded session
+type ReplayError struct {
+	// Seq is the sequence number of the recorded request,
+	// or 0 if the session had no more requests
+	Seq uint64
+	// Expected is the recorded request as JSON
+	Expected string
+	// Actual is the request the client sent as JSON
+	Actual string
+}
+
+func (e *ReplayError) Error() string {
+	if e.Seq == 0 {
+		return fmt.Sprintf("replay: unexpected request %s after the end of the session", e.Actual)
+	}
+
+	return fmt.Sprintf("replay: request %s does not match recorded request #%d %s", e.Actual, e.Seq, e.Expected)
+}
+
+// NewReplay reads a session saved through Client.Capture
+func NewReplay(r io.Reader) (*Replay, error) {
+	var (
+		cr      = bser.NewCaptureReader(r)
+		records []bser.Record
+	)
+
+	for {
+		rec, err := cr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	rp := &Replay{records: records}
+	rp.cond = sync.NewCond(&rp.mu)
+	rp.out = rp.nextOutgoing(0)
+
+	return rp, nil
+}
+
+// Replay plays back a recorded session in place of the watchman server,
+// so a Client can be tested without one. Each request the client sends
+// must match the next recorded request. The recorded responses and
+// unilateral messages are read by the client in their original order,
+// each once the requests recorded before it have been sent.
+type Replay struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	records   []bser.Record
+	in        int // index of the next record to be read
+	out       int // index of the next recorded request
+	buf       bytes.Buffer
+	pending   []byte
+	err       error
+	connected bool
+	closed    bool
+}
+
+// Client returns a Client connected to the replayed session.
+// A Replay can only be played once, by a single Client.
+func (rp *Replay) Client() *Client {
+	return &Client{Dial: rp.dial}
+}
+
+// Err returns the first request that didn't match the recorded session
+// as a *ReplayError, or an error if any recorded requests are yet to be
+// sent. It returns nil once the whole session has been played back.
+func (rp *Replay) Err() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.err != nil {
+		return rp.err
+	}
+
+	if rp.out < len(rp.records) {
+		return fmt.Errorf("replay: recorded request #%d was never sent", rp.records[rp.out].Seq)
+	}
+
+	return nil
+}
+
+func (rp *Replay) dial() (io.ReadWriteCloser, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.connected {
+		return nil, errors.New("replay: session already played")
+	}
+
+	rp.connected = true
+	return replayConn{rp}, nil
+}
+
+// nextOutgoing returns the index of the first recorded request at or after i
+func (rp *Replay) nextOutgoing(i int) int {
+	for i < len(rp.records) && rp.records[i].Direction != bser.Outgoing {
+		i++
+	}
+
+	return i
+}
+
+// replayConn is the connection to a Replay given to its Client
+type replayConn struct {
+	rp *Replay
+}
+
+func (c replayConn) Read(b []byte) (int, error) {
+	rp := c.rp
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	for rp.buf.Len() == 0 {
+		if rp.closed {
+			return 0, io.EOF
+		}
+
+		// skip past requests, which were matched by Write, to the
+		// next message that was received after all of them
+		for rp.in < rp.out && rp.records[rp.in].Direction != bser.Incoming {
+			rp.in++
+		}
+
+		if rp.in < rp.out {
+			rp.buf.Write(rp.records[rp.in].PDU)
+			rp.in++
+			continue
+		}
+
+		rp.cond.Wait()
+	}
+
+	return rp.buf.Read(b)
+}
+
+func (c replayConn) Write(b []byte) (int, error) {
+	rp := c.rp
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if rp.err != nil {
+		return 0, rp.err
+	}
+
+	rp.pending = append(rp.pending, b...)
+	for {
+		n, pdu, err := bser.SplitPDU(rp.pending, false)
+		if err != nil {
+			return 0, err
+		}
+		if pdu == nil {
+			return len(b), nil
+		}
+
+		if err := rp.match(pdu); err != nil {
+			rp.err = err
+			return 0, err
+		}
+
+		rp.pending = rp.pending[:copy(rp.pending, rp.pending[n:])]
+		rp.cond.Broadcast()
+	}
+}
+
+// match checks the PDU sent by the client against the next recorded request
+func (rp *Replay) match(pdu []byte) error {
+	actual := bser.Record{PDU: pdu}
+	if rp.out == len(rp.records) {
+		return &ReplayError{Actual: recordJSON(actual)}
+	}
+
+	expected := rp.records[rp.out]
+	eb, eerr := expected.Body()
+	ab, aerr := actual.Body()
+	if eerr != nil || aerr != nil || !bytes.Equal(eb, ab) {
+		return &ReplayError{Seq: expected.Seq, Expected: recordJSON(expected), Actual: recordJSON(actual)}
+	}
+
+	rp.out = rp.nextOutgoing(rp.out + 1)
+	return nil
+}
+
+func (c replayConn) Close() error {
+	rp := c.rp
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	rp.closed = true
+	rp.cond.Broadcast()
+
+	return nil
+}
+
+// recordJSON returns the value held by r as JSON, for error messages
+func recordJSON(r bser.Record) string {
+	body, err := r.Body()
+	if err != nil {
+		return fmt.Sprintf("<%s>", err)
+	}
+
+	js, err := bser.AppendJSON(nil, body)
+	if err != nil {
+		return fmt.Sprintf("<%s>", err)
+	}
+
+	return string(js)
+}
@@ -0,0 +1,343 @@
+package watchman
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+var record = flag.Bool("record", false, "record "+recordedSession+" against the watchman server started by TestMain")
+
+const (
+	// recordedSession is a session captured from a real watchman
+	// server by TestReplayRecorded with -record
+	recordedSession = "testdata/session.capture"
+	// recordedRoot is the root watched in the recorded session. It is
+	// fixed so the replayed requests match the recorded ones.
+	recordedRoot = "/tmp/watchman-replay-root"
+)
+
+// exchange is a message of a recorded session
+type exchange struct {
+	dir bser.Direction
+	v   interface{}
+}
+
+func sent(v interface{}) exchange     { return exchange{bser.Outgoing, v} }
+func received(v interface{}) exchange { return exchange{bser.Incoming, v} }
+
+// captureSession returns a capture file holding the session
+func captureSession(t *testing.T, session ...exchange) *bytes.Buffer {
+	t.Helper()
+
+	var (
+		buf bytes.Buffer
+		cw  = bser.NewCaptureWriter(&buf)
+	)
+
+	for i, ex := range session {
+		pdu, err := bser.MarshalPDU(ex.v)
+		if err != nil {
+			t.Fatalf("unexpected error marshaling %v: %s", ex.v, err)
+		}
+
+		r := bser.Record{Seq: uint64(i + 1), Time: time.Unix(int64(i+1), 0), Direction: ex.dir, PDU: pdu}
+		if err := cw.WriteRecord(r); err != nil {
+			t.Fatalf("unexpected error writing record: %s", err)
+		}
+	}
+
+	return &buf
+}
+
+func TestReplay(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0"}),
+		sent([]string{"log-level", "debug"}),
+		received(map[string]interface{}{"version": "4.9.0", "log_level": "debug"}),
+		received(map[string]interface{}{"log": "hello", "level": "debug", "unilateral": true}),
+		sent([]string{"watch-list"}),
+		received(map[string]interface{}{"version": "4.9.0", "roots": []string{"/a"}}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	cl := rp.Client()
+	defer cl.Close()
+
+	ch := make(chan interface{}, 1)
+	stop, err := cl.Receive(ch)
+	if err != nil {
+		t.Fatalf("unexpected error calling Receive: %s", err)
+	}
+	defer stop()
+
+	v, err := cl.Version()
+	if err != nil || v.Version != "4.9.0" {
+		t.Fatalf("unexpected version %#v, %v", v, err)
+	}
+
+	l, err := cl.LogLevel(LogLevelDebug)
+	if err != nil || l.LogLevel != LogLevelDebug {
+		t.Fatalf("unexpected log level %#v, %v", l, err)
+	}
+
+	select {
+	case m := <-ch:
+		if ev, ok := m.(*LogEvent); !ok || ev.Log != "hello" {
+			t.Fatalf("unexpected unilateral message %#v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the recorded log event")
+	}
+
+	if err := rp.Err(); err == nil {
+		t.Fatal("expected an error before the session is played back")
+	}
+
+	wl, err := cl.WatchList()
+	if err != nil || len(wl.Roots) != 1 || wl.Roots[0] != "/a" {
+		t.Fatalf("unexpected watch list %#v, %v", wl, err)
+	}
+
+	if err := rp.Err(); err != nil {
+		t.Fatalf("unexpected replay error: %s", err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0"}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	cl := rp.Client()
+	defer cl.Close()
+
+	_, err = cl.WatchList()
+	expectErrEqual(t, err, `replay: request ["watch-list"] does not match recorded request #1 ["version"]`)
+
+	if _, ok := rp.Err().(*ReplayError); !ok {
+		t.Fatalf("expected a *ReplayError, found %v", rp.Err())
+	}
+
+	if _, err := rp.Client().Version(); err == nil {
+		t.Fatal("unexpectedly no error playing a session twice")
+	}
+}
+
+func TestReplayEnd(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0"}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	cl := rp.Client()
+	defer cl.Close()
+
+	if _, err := cl.Version(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err = cl.Version()
+	expectErrEqual(t, err, `replay: unexpected request ["version"] after the end of the session`)
+}
+
+func TestReplayCapture(t *testing.T) {
+	const n = 2000
+
+	var capture bytes.Buffer
+	cl := &Client{Dial: fakeServer{Handler: echoHandler}.Dial, Capture: &capture}
+
+	for i := 0; i < n; i++ {
+		var data echo
+		if err := cl.Send(&data, "echo", strconv.Itoa(i)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	cl.Close()
+
+	// every response is recorded after its request
+	var (
+		cr      = bser.NewCaptureReader(bytes.NewReader(capture.Bytes()))
+		pending []string
+	)
+
+	for {
+		rec, err := cr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error reading capture: %s", err)
+		}
+
+		body, err := rec.Body()
+		if err != nil {
+			t.Fatalf("unexpected error reading record #%d: %s", rec.Seq, err)
+		}
+
+		if rec.Direction == bser.Outgoing {
+			var args []string
+			if err := bser.UnmarshalValue(body, &args); err != nil {
+				t.Fatalf("unexpected error decoding record #%d: %s", rec.Seq, err)
+			}
+			pending = append(pending, args[1])
+			continue
+		}
+
+		var data echo
+		if err := bser.UnmarshalValue(body, &data); err != nil {
+			t.Fatalf("unexpected error decoding record #%d: %s", rec.Seq, err)
+		}
+		if len(pending) == 0 || data.Args[1] != pending[0] {
+			t.Fatalf("response #%d to %q recorded ahead of its request", rec.Seq, data.Args[1])
+		}
+		pending = pending[1:]
+	}
+
+	rp, err := NewReplay(&capture)
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	rcl := rp.Client()
+	defer rcl.Close()
+
+	for i := 0; i < n; i++ {
+		var data echo
+		if err := rcl.Send(&data, "echo", strconv.Itoa(i)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if data.Args[1] != strconv.Itoa(i) {
+			t.Fatalf("unexpected response %q to request %d", data.Args, i)
+		}
+	}
+
+	if err := rp.Err(); err != nil {
+		t.Fatalf("unexpected replay error: %s", err)
+	}
+}
+
+func TestReplayRecorded(t *testing.T) {
+	if *record {
+		recordSession(t)
+	}
+
+	f, err := os.Open(recordedSession)
+	if os.IsNotExist(err) {
+		t.Skipf("%s has not been recorded; run go test -run TestReplayRecorded -record with watchman installed", recordedSession)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer f.Close()
+
+	rp, err := NewReplay(f)
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	cl := rp.Client()
+	defer cl.Close()
+
+	runRecordedSession(t, cl)
+
+	if err := rp.Err(); err != nil {
+		t.Fatalf("unexpected replay error: %s", err)
+	}
+}
+
+// recordSession captures runRecordedSession against the server started
+// by TestMain into recordedSession
+func recordSession(t *testing.T) {
+	if err := os.RemoveAll(recordedRoot); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(recordedRoot, "b"), 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(recordedRoot)
+
+	for name, data := range map[string]string{".watchmanconfig": "{}", "a.go": "package a\n", "b/c.go": "package b\n", "d.txt": "d\n"} {
+		if err := ioutil.WriteFile(filepath.Join(recordedRoot, name), []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	var capture bytes.Buffer
+	cl := &Client{Sockname: sock, Capture: &capture}
+	runRecordedSession(t, cl)
+	cl.Close()
+
+	if err := os.MkdirAll(filepath.Dir(recordedSession), 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(recordedSession, capture.Bytes(), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// runRecordedSession makes the requests of the recorded session,
+// checking only what doesn't depend on the server's version or host
+func runRecordedSession(t *testing.T, cl *Client) {
+	t.Helper()
+
+	v, err := cl.Version()
+	if err != nil || v.Version == "" {
+		t.Fatalf("unexpected version %#v, %v", v, err)
+	}
+
+	w, err := cl.WatchProject(recordedRoot)
+	if err != nil || w.Watch == "" {
+		t.Fatalf("unexpected watch %#v, %v", w, err)
+	}
+
+	c, err := cl.Clock(w.Watch)
+	if err != nil || c.Clock == "" {
+		t.Fatalf("unexpected clock %#v, %v", c, err)
+	}
+
+	// the files come back as a templated array
+	find, err := cl.Find(w.Watch, "*.go")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var names []string
+	for _, f := range find.Files {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if expected := []string{"a.go", "b/c.go"}; !reflect.DeepEqual(expected, names) {
+		t.Fatalf("unexpected files:\n\nexpected = %q\n\nactual = %q", expected, names)
+	}
+
+	var werr Error
+	if _, err := cl.Clock(recordedRoot + "-unwatched"); !errors.As(err, &werr) {
+		t.Fatalf("expected an error from watchman, found %v", err)
+	}
+
+	if _, err := cl.WatchDel(w.Watch); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
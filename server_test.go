@@ -0,0 +1,105 @@
+package watchman
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+// fakeServer is a server that answers the requests of a Client
+// over a net.Pipe, for tests that don't need a real watchman
+type fakeServer struct {
+	// Handler returns the response to each request, whose
+	// arguments must only hold strings. It is called in the
+	// order requests arrive on each connection.
+	Handler func(args []string) interface{}
+	// Latency delays each response after its request arrives, in
+	// order, as a server at the end of a slow link would
+	Latency time.Duration
+	// MaxUnanswered, if set, records the most requests that had
+	// arrived on a connection without being answered at once
+	MaxUnanswered *int64
+}
+
+// Dial opens a new connection to the server, for Client.Dial
+func (s fakeServer) Dial() (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+
+	type pending struct {
+		at   time.Time
+		resp interface{}
+	}
+
+	var unanswered int64
+
+	reqs := make(chan pending, 1024)
+	go func() {
+		defer close(reqs)
+
+		dec := bser.NewDecoder(server)
+		for {
+			var args []string
+			if err := dec.Decode(&args); err != nil {
+				return
+			}
+
+			n := atomic.AddInt64(&unanswered, 1)
+			if s.MaxUnanswered != nil {
+				storeMax(s.MaxUnanswered, n)
+			}
+
+			reqs <- pending{at: time.Now().Add(s.Latency), resp: s.Handler(args)}
+		}
+	}()
+
+	go func() {
+		defer server.Close()
+
+		enc := bser.NewEncoder(server)
+		for r := range reqs {
+			if s.Latency > 0 {
+				time.Sleep(time.Until(r.at))
+			}
+			// answered once written, before the client can send more
+			atomic.AddInt64(&unanswered, -1)
+			if err := enc.Encode(r.resp); err != nil {
+				return
+			}
+		}
+	}()
+
+	return client, nil
+}
+
+// storeMax atomically raises *p to n if it is lower
+func storeMax(p *int64, n int64) {
+	for {
+		max := atomic.LoadInt64(p)
+		if n <= max || atomic.CompareAndSwapInt64(p, max, n) {
+			return
+		}
+	}
+}
+
+// echoHandler answers each request with its arguments, or an
+// error for the command "fail"
+func echoHandler(args []string) interface{} {
+	if args[0] == "fail" {
+		return map[string]interface{}{"version": "1.0", "error": "unknown command fail"}
+	}
+
+	return map[string]interface{}{"version": "1.0", "args": args}
+}
+
+// clockHandler returns a Handler answering each request with
+// a distinct clock
+func clockHandler() func(args []string) interface{} {
+	var n int64
+	return func(args []string) interface{} {
+		return map[string]string{"version": "1.0", "clock": fmt.Sprintf("c:%d", atomic.AddInt64(&n, 1))}
+	}
+}
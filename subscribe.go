@@ -8,6 +8,7 @@ import (
 type Subscribe struct {
 	Clock     string `bser:"clock"`
 	Subscribe string `bser:"subscribe"`
+	Warning   string `bser:"warning"`
 }
 
 // SubscribeEvent is the unilateral message that indicates an
@@ -19,6 +20,7 @@ type SubscribeEvent struct {
 	Root            string          `bser:"root"`
 	Since           string          `bser:"since"`
 	Subscription    string          `bser:"subscription"`
+	Warning         string          `bser:"warning"`
 }
 
 // SubscribeFile is a representation of the file that was somehow changed
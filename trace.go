@@ -0,0 +1,94 @@
+package watchman
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+// Logger receives the diagnostic messages of a Client.
+// *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Tracer holds functions called at points in the life of a Client's
+// connection. Any of them may be nil. They are called from the client's
+// goroutines, so they must be safe for concurrent use and must not block.
+type Tracer struct {
+	// RequestStart is called when Send is called with args
+	RequestStart func(args []interface{})
+	// RequestDone is called when Send returns for args, with the error
+	// it returns and the time it took. Errors reported by watchman in
+	// the response aren't included.
+	RequestDone func(args []interface{}, err error, elapsed time.Duration)
+	// PDU is called with each PDU read from or written to the server
+	PDU func(r bser.Record)
+	// DroppedUnilateral is called with each unilateral message that
+	// is not delivered to Receive, and why
+	DroppedUnilateral func(msg bser.RawMessage, reason error)
+	// DecodeError is called when a unilateral message can't be decoded,
+	// or the connection fails while there is no request to return the
+	// error to
+	DecodeError func(err error)
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// pduLogger returns the Logger PDUs are logged to, or nil
+// if they aren't logged
+func (c *Client) pduLogger() Logger {
+	if !c.LogPDUs {
+		return nil
+	}
+	if c.Logger != nil {
+		return c.Logger
+	}
+
+	return log.New(os.Stderr, "", 0)
+}
+
+func (c *Client) traceRequestStart(args []interface{}) {
+	if c.Tracer != nil && c.Tracer.RequestStart != nil {
+		c.Tracer.RequestStart(args)
+	}
+}
+
+func (c *Client) traceRequestDone(args []interface{}, err error, start time.Time) {
+	if c.Tracer != nil && c.Tracer.RequestDone != nil {
+		c.Tracer.RequestDone(args, err, time.Since(start))
+	}
+}
+
+func (c *Client) traceDropped(msg bser.RawMessage, reason error) {
+	c.logf("watchman: dropped unilateral message: %s", reason)
+	if c.Tracer != nil && c.Tracer.DroppedUnilateral != nil {
+		c.Tracer.DroppedUnilateral(msg, reason)
+	}
+}
+
+func (c *Client) traceDecodeError(err error) {
+	if c.Tracer != nil && c.Tracer.DecodeError != nil {
+		c.Tracer.DecodeError(err)
+	}
+}
+
+// logRecord logs the PDU of r to l as JSON
+func logRecord(l Logger, r bser.Record) {
+	body, err := r.Body()
+	if err == nil {
+		var js []byte
+		if js, err = bser.AppendJSON(nil, body); err == nil {
+			l.Printf("[pdu logger - %s]: %s", r.Direction, js)
+			return
+		}
+	}
+
+	l.Printf("[pdu logger - %s - bser to json error]: %s", r.Direction, err)
+}
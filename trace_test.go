@@ -0,0 +1,180 @@
+package watchman
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonasi/watchman/bser"
+)
+
+// logRecorder is a Logger that keeps the messages logged to it
+type logRecorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *logRecorder) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+func TestTracer(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0"}),
+		received(map[string]interface{}{"unilateral": true, "state-enter": "hg.update"}),
+		sent([]string{"watch-list"}),
+		received(map[string]interface{}{"error": "unable to list watches"}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	// requests and PDUs are recorded separately, as unilateral
+	// messages may be read while a request completes
+	var (
+		mu       sync.Mutex
+		requests []string
+		pdus     []string
+		dropped  = make(chan bser.RawMessage, 1)
+		logger   logRecorder
+	)
+
+	event := func(events *[]string, format string, v ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		*events = append(*events, fmt.Sprintf(format, v...))
+	}
+
+	cl := rp.Client()
+	cl.Logger = &logger
+	cl.Tracer = &Tracer{
+		RequestStart: func(args []interface{}) {
+			event(&requests, "start %v", args)
+		},
+		RequestDone: func(args []interface{}, err error, elapsed time.Duration) {
+			event(&requests, "done %v %v", args, err)
+		},
+		PDU: func(r bser.Record) {
+			event(&pdus, "pdu %d %s", r.Seq, r.Direction)
+		},
+		DroppedUnilateral: func(msg bser.RawMessage, reason error) {
+			dropped <- msg
+		},
+	}
+	defer cl.Close()
+
+	if _, err := cl.Version(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case msg := <-dropped:
+		if s, _ := msg.Value().Get("state-enter").AsString(); s != "hg.update" {
+			t.Fatalf("unexpected dropped message %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the unknown unilateral message to be dropped")
+	}
+
+	if _, err := cl.WatchList(); err == nil {
+		t.Fatal("unexpectedly no error")
+	}
+
+	var (
+		expectedRequests = []string{
+			"start [version]",
+			"done [version] <nil>",
+			"start [watch-list]",
+			"done [watch-list] <nil>",
+		}
+		expectedPDUs = []string{
+			"pdu 1 outgoing",
+			"pdu 2 incoming",
+			"pdu 3 incoming",
+			"pdu 4 outgoing",
+			"pdu 5 incoming",
+		}
+	)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(requests, "\n") != strings.Join(expectedRequests, "\n") {
+		t.Fatalf("unexpected requests:\n\nexpected = %q\n\nactual = %q", expectedRequests, requests)
+	}
+	if strings.Join(pdus, "\n") != strings.Join(expectedPDUs, "\n") {
+		t.Fatalf("unexpected PDUs:\n\nexpected = %q\n\nactual = %q", expectedPDUs, pdus)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.msgs) != 1 || logger.msgs[0] != "watchman: dropped unilateral message: unknown message type" {
+		t.Fatalf("unexpected log messages %q", logger.msgs)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	client, server := net.Pipe()
+
+	go func() {
+		// read the request, then hang up without responding
+		bser.NewDecoder(server).Decode(new(interface{}))
+		server.Close()
+	}()
+
+	cl := &Client{Dial: func() (io.ReadWriteCloser, error) {
+		return client, nil
+	}}
+	defer cl.Close()
+
+	_, err := cl.Version()
+	expectErrEqual(t, err, "EOF")
+
+	// later requests fail with the same error rather than waiting
+	_, err = cl.Version()
+	expectErrEqual(t, err, "EOF")
+}
+
+func TestLogPDUs(t *testing.T) {
+	for _, logPDUs := range []bool{false, true} {
+		rp, err := NewReplay(captureSession(t,
+			sent([]string{"version"}),
+			received(map[string]interface{}{"version": "4.9.0"}),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error reading session: %s", err)
+		}
+
+		var logger logRecorder
+
+		cl := rp.Client()
+		cl.Logger = &logger
+		cl.LogPDUs = logPDUs
+
+		if _, err := cl.Version(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		cl.Close()
+
+		var expected []string
+		if logPDUs {
+			expected = []string{
+				`[pdu logger - outgoing]: ["version"]`,
+				`[pdu logger - incoming]: {"version":"4.9.0"}`,
+			}
+		}
+
+		logger.mu.Lock()
+		if strings.Join(logger.msgs, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("unexpected log messages with LogPDUs %v:\n\nexpected = %q\n\nactual = %q", logPDUs, expected, logger.msgs)
+		}
+		logger.mu.Unlock()
+	}
+}
@@ -6,6 +6,7 @@ import "github.com/yookoala/realpath"
 type Unsubscribe struct {
 	Deleted     bool   `bser:"deleted"`
 	Unsubscribe string `bser:"unsubscribe"`
+	Warning     string `bser:"warning"`
 }
 
 // Unsubscribe cancels a named subscription against the specified root. The server side will no longer generate subscription packets for the specified subscription.
@@ -3,12 +3,16 @@ package watchman
 // Version is the return object of the Version call
 type Version struct {
 	Version string
+	Warning string
 }
 
 // Version will tell you the version and build information for the currently running watchman service
 // https://facebook.github.io/watchman/docs/cmd/version.html
 func (c *Client) Version() (*Version, error) {
-	var data base
+	var data struct {
+		base
+		Warning string `bser:"warning"`
+	}
 
 	if err := c.Send(&data, "version"); err != nil {
 		return nil, err
@@ -18,5 +22,5 @@ func (c *Client) Version() (*Version, error) {
 		return nil, data.Error
 	}
 
-	return &Version{Version: data.Version}, nil
+	return &Version{Version: data.Version, Warning: data.Warning}, nil
 }
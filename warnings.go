@@ -0,0 +1,42 @@
+package watchman
+
+import (
+	"strconv"
+	"strings"
+)
+
+// recrawlPrefix starts the warning the server sends once it has had
+// to recrawl a root
+const recrawlPrefix = "Recrawled this watch "
+
+// RecrawlCount returns the number of times a root has been recrawled
+// according to warning, and whether it is a recrawl warning at all.
+// Watchman recrawls a root when it loses track of changes to it, such
+// as when the kernel's event queue overflows, so a growing count means
+// changes are being missed.
+func RecrawlCount(warning string) (int, bool) {
+	i := strings.Index(warning, recrawlPrefix)
+	if i < 0 {
+		return 0, false
+	}
+
+	s := warning[i+len(recrawlPrefix):]
+	if j := strings.IndexByte(s, ' '); j >= 0 {
+		s = s[:j]
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// warn reports a warning from the server for command
+func (c *Client) warn(command, warning string) {
+	c.logf("watchman: warning from %s: %s", command, warning)
+	if c.OnWarning != nil {
+		c.OnWarning(command, warning)
+	}
+}
@@ -0,0 +1,146 @@
+package watchman
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const recrawlWarning = "Recrawled this watch 3 times, most recently because:\n/a: kFSEventStreamEventFlagUserDropped\n"
+
+func TestWarnings(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"watch-list"}),
+		received(map[string]interface{}{"version": "4.9.0", "roots": []string{"/a"}, "warning": recrawlWarning}),
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0", "warning": "deprecated"}),
+		sent([]string{"log-level", "debug"}),
+		received(map[string]interface{}{"version": "4.9.0", "log_level": "debug"}),
+		received(map[string]interface{}{"unilateral": true, "subscription": "s", "warning": recrawlWarning}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	var (
+		warnings [][2]string
+		received = make(chan struct{})
+	)
+
+	cl := rp.Client()
+	cl.OnWarning = func(command, warning string) {
+		warnings = append(warnings, [2]string{command, warning})
+		if command == "subscribe" {
+			close(received)
+		}
+	}
+	defer cl.Close()
+
+	wl, err := cl.WatchList()
+	if err != nil || wl.Warning != recrawlWarning {
+		t.Fatalf("unexpected watch list %#v, %v", wl, err)
+	}
+
+	v, err := cl.Version()
+	if err != nil || v.Warning != "deprecated" {
+		t.Fatalf("unexpected version %#v, %v", v, err)
+	}
+
+	ch := make(chan interface{}, 1)
+	if _, err := cl.Receive(ch); err != nil {
+		t.Fatalf("unexpected error calling Receive: %s", err)
+	}
+
+	if _, err := cl.LogLevel(LogLevelDebug); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("expected the subscription warning")
+	}
+
+	expected := [][2]string{
+		{"watch-list", recrawlWarning},
+		{"version", "deprecated"},
+		{"subscribe", recrawlWarning},
+	}
+	if !reflect.DeepEqual(expected, warnings) {
+		t.Fatalf("unexpected warnings:\n\nexpected = %q\n\nactual = %q", expected, warnings)
+	}
+}
+
+func TestRecrawlCount(t *testing.T) {
+	var recrawlCountTests = map[string]struct {
+		warning  string
+		expected int
+		ok       bool
+	}{
+		"recrawl":     {warning: recrawlWarning, expected: 3, ok: true},
+		"once":        {warning: "Recrawled this watch 1 time, most recently because:", expected: 1, ok: true},
+		"other":       {warning: "deprecated", ok: false},
+		"not_numeric": {warning: "Recrawled this watch many times", ok: false},
+	}
+
+	for testName, testCase := range recrawlCountTests {
+		t.Run(testName, func(t *testing.T) {
+			n, ok := RecrawlCount(testCase.warning)
+			if n != testCase.expected || ok != testCase.ok {
+				t.Fatalf("unexpected result %d, %t", n, ok)
+			}
+		})
+	}
+}
+
+func TestWarningsDoNotBlockReads(t *testing.T) {
+	rp, err := NewReplay(captureSession(t,
+		sent([]string{"version"}),
+		received(map[string]interface{}{"version": "4.9.0"}),
+		received(map[string]interface{}{"unilateral": true, "subscription": "s", "warning": recrawlWarning}),
+		sent([]string{"watch-list"}),
+		received(map[string]interface{}{"version": "4.9.0", "roots": []string{"/a"}}),
+	))
+	if err != nil {
+		t.Fatalf("unexpected error reading session: %s", err)
+	}
+
+	var (
+		warned  = make(chan struct{})
+		release = make(chan struct{})
+	)
+	defer close(release)
+
+	cl := rp.Client()
+	cl.OnWarning = func(command, warning string) {
+		close(warned)
+		<-release
+	}
+	defer cl.Close()
+
+	if _, err := cl.Version(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case <-warned:
+	case <-time.After(time.Second):
+		t.Fatal("expected the subscription warning")
+	}
+
+	// OnWarning is still blocked, and the response is read regardless
+	done := make(chan error, 1)
+	go func() {
+		_, err := cl.WatchList()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a response while OnWarning blocks")
+	}
+}
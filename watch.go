@@ -8,6 +8,7 @@ import (
 type Watch struct {
 	Watch   string
 	Watcher string
+	Warning string
 }
 
 // Watch requests that the specified dir is watched for changes
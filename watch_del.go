@@ -8,6 +8,7 @@ import (
 type WatchDel struct {
 	Root     string `bser:"root"`
 	WatchDel bool   `bser:"watch-del"`
+	Warning  string `bser:"warning"`
 }
 
 // WatchDel removes a watch and any associated triggers
@@ -6,7 +6,8 @@ import (
 
 // WatchDelAll is the return object of the WatchDelAll call
 type WatchDelAll struct {
-	Roots []string `bser:"roots"`
+	Roots   []string `bser:"roots"`
+	Warning string   `bser:"warning"`
 }
 
 // WatchDelAll removes all watches and associated triggers
@@ -2,7 +2,8 @@ package watchman
 
 // WatchList is the return object of the WatchList call
 type WatchList struct {
-	Roots []string
+	Roots   []string
+	Warning string
 }
 
 // WatchList returns a list of watched dirs
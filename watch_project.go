@@ -5,6 +5,7 @@ type WatchProject struct {
 	Version string
 	Watch   string
 	Watcher string
+	Warning string
 }
 
 // WatchProject requests that the project containing the requested dir is watched for changes
@@ -0,0 +1,407 @@
+// Package watchmantest provides an in-memory fake of the watchman
+// client for testing code that depends on watchman.API
+package watchmantest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jonasi/watchman"
+	"github.com/jonasi/watchman/bser"
+)
+
+// ErrClosed is returned by the methods of a Fake after Close is called
+var ErrClosed = errors.New("watchmantest: fake is closed")
+
+var _ watchman.API = (*Fake)(nil)
+
+// Call is a command received by a Fake
+type Call struct {
+	Command string
+	// Args are the arguments that follow the command
+	Args []interface{}
+}
+
+type response struct {
+	result interface{}
+	err    error
+}
+
+// NewFake returns a Fake with no scripted responses
+func NewFake() *Fake {
+	return &Fake{responses: map[string][]response{}}
+}
+
+// Fake is an in-memory implementation of watchman.API. It records each
+// call made to it and answers with the responses scripted for the
+// command, then those of Handler, then empty results. Events emitted
+// with Emit are delivered to Receive and Subscribe as the server's
+// unilateral messages would be.
+//
+// Results are copied into the value returned to the caller by encoding
+// them as BSER, so a scripted result can be the command's result type,
+// such as watchman.Clock, or any value that encodes the same way, such
+// as a map[string]interface{}.
+type Fake struct {
+	// Handler, if set, answers calls for which no response is scripted
+	Handler func(command string, args []interface{}) (interface{}, error)
+
+	mu        sync.Mutex
+	calls     []Call
+	responses map[string][]response
+	receivers []*receiver
+	closed    bool
+}
+
+// receiver is a channel registered with Receive or Subscribe
+type receiver struct {
+	ch    chan<- interface{}
+	subCh chan<- *watchman.SubscribeEvent
+	name  string // subscription name, for subCh
+
+	// done is closed when the receiver is stopped, which makes sends
+	// in progress give up. They hold mu for reading, so stop can wait
+	// for them before closing the channel.
+	done     chan struct{}
+	stopOnce sync.Once
+	mu       sync.RWMutex
+}
+
+func newReceiver(ch chan<- interface{}, subCh chan<- *watchman.SubscribeEvent, name string) *receiver {
+	return &receiver{ch: ch, subCh: subCh, name: name, done: make(chan struct{})}
+}
+
+// Respond scripts the response to the next call of command that has
+// no response scripted yet. A non-nil err, such as a watchman.Error, is
+// returned in place of the result.
+func (f *Fake) Respond(command string, result interface{}, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.responses[command] = append(f.responses[command], response{result, err})
+}
+
+// Calls returns the calls made so far, in order
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Call(nil), f.calls...)
+}
+
+// Emit delivers the event v to every channel passed to Receive and, if
+// it is a *watchman.SubscribeEvent, to the channel of its subscription.
+// It blocks until each of them has received it or been stopped.
+func (f *Fake) Emit(v interface{}) {
+	f.mu.Lock()
+	receivers := append([]*receiver(nil), f.receivers...)
+	f.mu.Unlock()
+
+	ev, _ := v.(*watchman.SubscribeEvent)
+	for _, r := range receivers {
+		if r.ch != nil || (ev != nil && ev.Subscription == r.name) {
+			r.send(v, ev)
+		}
+	}
+}
+
+// send sends v to the channel of r, or ev to its subscription
+// channel, unless r is stopped first
+func (r *receiver) send(v interface{}, ev *watchman.SubscribeEvent) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	if r.ch != nil {
+		select {
+		case r.ch <- v:
+		case <-r.done:
+		}
+		return
+	}
+
+	select {
+	case r.subCh <- ev:
+	case <-r.done:
+	}
+}
+
+// stop closes the channel of r once any sends in progress are abandoned
+func (r *receiver) stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.ch != nil {
+			close(r.ch)
+		} else {
+			close(r.subCh)
+		}
+	})
+}
+
+// call records a call of command with args, and decodes its response into dest
+func (f *Fake) call(dest interface{}, command string, args ...interface{}) error {
+	return f.do(dest, nil, command, args...)
+}
+
+// do makes a call as call does, and also decodes the response into
+// resp, if it is not nil, as the client does for calls made with Go
+func (f *Fake) do(dest interface{}, resp *watchman.Response, command string, args ...interface{}) error {
+	f.mu.Lock()
+
+	if f.closed {
+		f.mu.Unlock()
+		return ErrClosed
+	}
+
+	f.calls = append(f.calls, Call{Command: command, Args: args})
+
+	var (
+		r       response
+		queued  = f.responses[command]
+		handler = f.Handler
+	)
+
+	if len(queued) > 0 {
+		r = queued[0]
+		f.responses[command] = queued[1:]
+		handler = nil
+	}
+
+	f.mu.Unlock()
+
+	if handler != nil {
+		r.result, r.err = handler(command, args)
+	}
+
+	if r.err != nil {
+		// the server reports its errors in the response
+		var werr watchman.Error
+		if resp != nil && errors.As(r.err, &werr) {
+			resp.Error = werr
+		}
+
+		return r.err
+	}
+	if r.result == nil {
+		return nil
+	}
+
+	b, err := bser.MarshalValue(r.result)
+	if err != nil {
+		return err
+	}
+
+	if resp != nil {
+		if err := bser.UnmarshalValue(b, resp); err != nil {
+			return err
+		}
+	}
+	if dest == nil {
+		return nil
+	}
+
+	return bser.UnmarshalValue(b, dest)
+}
+
+// register adds r to the receivers of events and returns the func that stops it
+func (f *Fake) register(r *receiver) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrClosed
+	}
+
+	f.receivers = append(f.receivers, r)
+	return r.stop, nil
+}
+
+// Send records a call of the command in args and decodes its response into dest
+func (f *Fake) Send(dest interface{}, args ...interface{}) error {
+	command, rest := splitCommand(args)
+	return f.call(dest, command, rest...)
+}
+
+// Go records a call as Send does. The returned Call is already done,
+// with its Response decoded from the result as the client decodes it
+// from the server's response.
+func (f *Fake) Go(dest interface{}, args ...interface{}) *watchman.Call {
+	var (
+		command, rest = splitCommand(args)
+		resp          watchman.Response
+	)
+
+	call := watchman.NewDoneCall(dest, args, f.do(dest, &resp, command, rest...))
+	call.Response = resp
+
+	return call
+}
+
+// splitCommand splits the args of Send or Go into the command and its arguments
+func splitCommand(args []interface{}) (string, []interface{}) {
+	if len(args) == 0 {
+		return "", args
+	}
+
+	command, _ := args[0].(string)
+	return command, args[1:]
+}
+
+// NewBatch returns an empty Batch of calls made with Go
+func (f *Fake) NewBatch() *watchman.Batch {
+	return watchman.NewBatch(f.Go)
+}
+
+// Receive delivers every event emitted by Emit to ch, until the returned func is called
+func (f *Fake) Receive(ch chan<- interface{}) (func(), error) {
+	return f.register(newReceiver(ch, nil, ""))
+}
+
+// Close stops all receivers, after which every call returns ErrClosed
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+
+	f.closed = true
+	receivers := f.receivers
+	f.mu.Unlock()
+
+	for _, r := range receivers {
+		r.stop()
+	}
+
+	return nil
+}
+
+// Clock records a clock call
+func (f *Fake) Clock(path string) (*watchman.Clock, error) {
+	var r watchman.Clock
+	if err := f.call(&r, "clock", path); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Find records a find call
+func (f *Fake) Find(path string, patterns ...string) (*watchman.Find, error) {
+	args := []interface{}{path}
+	for _, p := range patterns {
+		args = append(args, p)
+	}
+
+	var r watchman.Find
+	if err := f.call(&r, "find", args...); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// LogLevel records a log-level call
+func (f *Fake) LogLevel(level string) (*watchman.LogLevel, error) {
+	var r watchman.LogLevel
+	if err := f.call(&r, "log-level", level); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Subscribe records a subscribe call. Events emitted for the subscription
+// are delivered to ch until the returned func is called, which closes ch.
+func (f *Fake) Subscribe(path, name string, expr map[string]interface{}, ch chan<- *watchman.SubscribeEvent) (*watchman.Subscribe, func(), error) {
+	var r watchman.Subscribe
+	if err := f.call(&r, "subscribe", path, name, expr); err != nil {
+		return nil, nil, err
+	}
+
+	stop, err := f.register(newReceiver(nil, ch, name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &r, stop, nil
+}
+
+// Unsubscribe records an unsubscribe call
+func (f *Fake) Unsubscribe(path, name string) (*watchman.Unsubscribe, error) {
+	var r watchman.Unsubscribe
+	if err := f.call(&r, "unsubscribe", path, name); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Version records a version call
+func (f *Fake) Version() (*watchman.Version, error) {
+	var r watchman.Version
+	if err := f.call(&r, "version"); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Watch records a watch call
+func (f *Fake) Watch(path string) (*watchman.Watch, error) {
+	var r watchman.Watch
+	if err := f.call(&r, "watch", path); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// WatchDel records a watch-del call
+func (f *Fake) WatchDel(path string) (*watchman.WatchDel, error) {
+	var r watchman.WatchDel
+	if err := f.call(&r, "watch-del", path); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// WatchDelAll records a watch-del-all call
+func (f *Fake) WatchDelAll(path string) (*watchman.WatchDelAll, error) {
+	var r watchman.WatchDelAll
+	if err := f.call(&r, "watch-del-all", path); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// WatchList records a watch-list call
+func (f *Fake) WatchList() (*watchman.WatchList, error) {
+	var r watchman.WatchList
+	if err := f.call(&r, "watch-list"); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// WatchProject records a watch-project call
+func (f *Fake) WatchProject(path string) (*watchman.WatchProject, error) {
+	var r watchman.WatchProject
+	if err := f.call(&r, "watch-project", path); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,214 @@
+package watchmantest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonasi/watchman"
+)
+
+// watchRoot is an example of code under test depending on watchman.API
+func watchRoot(api watchman.API, path string) (string, error) {
+	w, err := api.WatchProject(path)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := api.Clock(w.Watch)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Clock, nil
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake()
+	f.Respond("watch-project", watchman.WatchProject{Watch: "/src"}, nil)
+	f.Respond("clock", map[string]string{"clock": "c:1:2"}, nil)
+	f.Respond("clock", nil, watchman.Error("unable to resolve root /src"))
+
+	clock, err := watchRoot(f, "/src/pkg")
+	if err != nil || clock != "c:1:2" {
+		t.Fatalf("unexpected clock %q, %v", clock, err)
+	}
+
+	if _, err := watchRoot(f, "/src/pkg"); err != watchman.Error("unable to resolve root /src") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// nothing more is scripted
+	if w, err := f.WatchProject("/other"); err != nil || w.Watch != "" {
+		t.Fatalf("unexpected result %#v, %v", w, err)
+	}
+
+	expected := []Call{
+		{Command: "watch-project", Args: []interface{}{"/src/pkg"}},
+		{Command: "clock", Args: []interface{}{"/src"}},
+		{Command: "watch-project", Args: []interface{}{"/src/pkg"}},
+		{Command: "clock", Args: []interface{}{""}},
+		{Command: "watch-project", Args: []interface{}{"/other"}},
+	}
+	if !reflect.DeepEqual(expected, f.Calls()) {
+		t.Fatalf("unexpected calls:\n\nexpected = %#v\n\nactual = %#v", expected, f.Calls())
+	}
+}
+
+func TestFakeHandler(t *testing.T) {
+	f := NewFake()
+	f.Handler = func(command string, args []interface{}) (interface{}, error) {
+		return map[string]interface{}{"version": "4.9.0", "roots": []string{"/a"}}, nil
+	}
+
+	var data struct {
+		Version string `bser:"version"`
+	}
+	if err := f.Send(&data, "version"); err != nil || data.Version != "4.9.0" {
+		t.Fatalf("unexpected response %#v, %v", data, err)
+	}
+
+	if wl, err := f.WatchList(); err != nil || !reflect.DeepEqual(wl.Roots, []string{"/a"}) {
+		t.Fatalf("unexpected watch list %#v, %v", wl, err)
+	}
+}
+
+func TestFakeEvents(t *testing.T) {
+	f := NewFake()
+
+	var (
+		all  = make(chan interface{}, 2)
+		subs = make(chan *watchman.SubscribeEvent, 1)
+	)
+
+	stopAll, err := f.Receive(all)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, _, err := f.Subscribe("/src", "mysub", nil, subs); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f.Emit(&watchman.SubscribeEvent{Subscription: "other"})
+	f.Emit(&watchman.SubscribeEvent{Subscription: "mysub", Clock: "c:1"})
+
+	if ev := <-subs; ev.Clock != "c:1" {
+		t.Fatalf("unexpected event %#v", ev)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected Receive to get both events, found %d", len(all))
+	}
+
+	stopAll()
+	f.Emit(&watchman.LogEvent{Log: "after stop"})
+
+	// the closed channel holds only the events sent before it was stopped
+	var n int
+	for range all {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 events, found %d", n)
+	}
+
+	f.Close()
+	if _, ok := <-subs; ok {
+		t.Fatal("expected Close to close the subscription channel")
+	}
+	if _, err := f.Version(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, found %v", err)
+	}
+}
+
+func TestFakeStopBlockedEmit(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(f *Fake, stop func())
+	}{
+		{"stop", func(f *Fake, stop func()) { stop() }},
+		{"close", func(f *Fake, stop func()) { f.Close() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFake()
+
+			// nothing reads from ch, so Emit blocks
+			ch := make(chan interface{})
+			stop, err := f.Receive(ch)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			emitted := make(chan struct{})
+			go func() {
+				defer close(emitted)
+				f.Emit(&watchman.LogEvent{Log: "blocked"})
+			}()
+
+			// give Emit time to block on the send
+			time.Sleep(10 * time.Millisecond)
+
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				tt.stop(f, stop)
+				f.Calls()
+			}()
+
+			for _, done := range []chan struct{}{stopped, emitted} {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("deadlocked stopping a receiver during Emit")
+				}
+			}
+
+			if _, ok := <-ch; ok {
+				t.Fatal("expected the receiver's channel to be closed")
+			}
+		})
+	}
+}
+
+func TestFakeBatch(t *testing.T) {
+	f := NewFake()
+	f.Respond("clock", map[string]string{"version": "4.9.0", "clock": "c:1:2", "warning": "recrawled"}, nil)
+	f.Respond("version", nil, watchman.Error("unknown command version"))
+
+	var (
+		b     = f.NewBatch()
+		clock watchman.Clock
+	)
+
+	call := b.Add(&clock, "clock", "/src")
+	failed := b.Add(nil, "version")
+
+	select {
+	case <-call.Done():
+	default:
+		t.Fatal("expected the call to be done")
+	}
+
+	if err := b.Wait(); err != watchman.Error("unknown command version") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if clock.Clock != "c:1:2" {
+		t.Fatalf("unexpected clock %q", clock.Clock)
+	}
+	if expected := (watchman.Response{Version: "4.9.0", Warning: "recrawled"}); call.Response != expected {
+		t.Fatalf("unexpected response:\n\nexpected = %#v\n\nactual = %#v", expected, call.Response)
+	}
+	if expected := (watchman.Response{Error: "unknown command version"}); failed.Response != expected {
+		t.Fatalf("unexpected response:\n\nexpected = %#v\n\nactual = %#v", expected, failed.Response)
+	}
+
+	expected := []Call{
+		{Command: "clock", Args: []interface{}{"/src"}},
+		{Command: "version", Args: []interface{}{}},
+	}
+	if !reflect.DeepEqual(expected, f.Calls()) {
+		t.Fatalf("unexpected calls:\n\nexpected = %#v\n\nactual = %#v", expected, f.Calls())
+	}
+}